@@ -0,0 +1,158 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+
+	"github.com/redstarcoder/go-starfish/starfish"
+)
+
+// libPath is the import path of the library compiled programs use.
+const libPath = "github.com/redstarcoder/go-starfish/starfish"
+
+// build implements "starfish build", compiling the file named in args to a Go program and building it with the
+// go command. It returns the exit code.
+func build(args []string) int {
+	flags := flag.NewFlagSet("build", flag.ExitOnError)
+	out := flags.String("o", "", "write the program to this file, or its Go source if it ends in .go (default: the script's name without its extension)")
+	lib := flags.String("lib", "", "build against the library in this directory instead of the version starfish was built with (needed if that can't be found)")
+	useUTF8 := flags.Bool("utf8", false, "read the script and input as UTF-8 instead of bytes")
+	num := flags.String("num", "float64", "arithmetic to use: float64, int64 or rational")
+	compat := flags.Bool("m", false, "run like the fishlanguage.com interpreter")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage:", fName, "build [args] <file>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	name := flags.Arg(0)
+	b, err := ioutil.ReadFile(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	opts := starfish.CompileOptions{Name: filepath.Base(name), Compat: *compat}
+	if *useUTF8 {
+		opts.Encoding = starfish.UTF8
+	}
+	switch *num {
+	case "float64":
+	case "int64":
+		opts.Numeric = starfish.Int64Numeric{}
+	case "rational":
+		opts.Numeric = starfish.RationalNumeric{}
+	default:
+		fmt.Fprintln(os.Stderr, "unknown -num:", *num)
+		return 2
+	}
+	src, err := starfish.Compile(string(b), opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	if *out == "" {
+		*out = strings.TrimSuffix(name, filepath.Ext(name))
+		if *out == name {
+			*out += ".out"
+		}
+	}
+	if strings.HasSuffix(*out, ".go") {
+		if err = ioutil.WriteFile(*out, src, 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return 0
+	}
+	if err = goBuild(src, *out, *lib); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+// goBuild builds the program with source src to out, in a temporary module requiring the library in dir, or
+// the version of it starfish was built with if dir is empty.
+func goBuild(src []byte, out, dir string) error {
+	out, err := filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir("", "starfish-build")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	mod, err := goMod(dir)
+	if err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(filepath.Join(tmp, "go.mod"), []byte(mod), 0644); err != nil {
+		return err
+	}
+	if err = ioutil.WriteFile(filepath.Join(tmp, "main.go"), src, 0644); err != nil {
+		return err
+	}
+	cmd := exec.Command("go", "build", "-mod=mod", "-o", out, ".")
+	cmd.Dir = tmp
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("go build: %v", err)
+	}
+	return nil
+}
+
+// goMod returns the go.mod of a compiled program, requiring the library in dir, or the version of it starfish
+// was built with if dir is empty. It fails if dir is empty and that version can't be fetched or found on disk.
+func goMod(dir string) (string, error) {
+	version, replace := "", ""
+	if dir != "" {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return "", err
+		}
+		version, replace = "v0.0.0", abs
+	} else if info, ok := debug.ReadBuildInfo(); ok {
+		for _, m := range info.Deps {
+			if m.Path != libPath {
+				continue
+			}
+			if m.Version != "" && m.Version != "(devel)" {
+				version = m.Version
+			}
+			if r := m.Replace; r != nil {
+				switch {
+				case r.Version != "" && r.Version != "(devel)":
+					replace = r.Path + " " + r.Version
+				case filepath.IsAbs(r.Path):
+					replace = r.Path
+				default:
+					// A relative directory means nothing outside the module starfish was built in
+					version = ""
+				}
+				if replace != "" && version == "" {
+					version = "v0.0.0"
+				}
+			}
+		}
+	}
+	if version == "" {
+		return "", fmt.Errorf("can't tell which version of %s starfish was built with, so -lib is needed", libPath)
+	}
+	mod := fmt.Sprintf("module fishprog\n\ngo 1.14\n\nrequire %s %s\n", libPath, version)
+	if replace != "" {
+		mod += fmt.Sprintf("\nreplace %s => %s\n", libPath, replace)
+	}
+	return mod, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/redstarcoder/go-starfish/starfish"
+)
+
+// cfg implements "starfish cfg", writing the control-flow graph of the file named in args to stdout as DOT. It
+// returns the exit code.
+func cfg(args []string) int {
+	flags := flag.NewFlagSet("cfg", flag.ExitOnError)
+	useUTF8 := flags.Bool("utf8", false, "read the script as UTF-8 instead of bytes")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage:", fName, "cfg [args] <file>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	b, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	var opts starfish.LintOptions
+	if *useUTF8 {
+		opts.Encoding = starfish.UTF8
+	}
+	if err := starfish.NewCFG(string(b), opts).WriteDOT(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
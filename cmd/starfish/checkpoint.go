@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/redstarcoder/go-starfish/starfish"
+)
+
+// saveCheckpoint writes the state of cB to fName. The file is replaced only once the new state is fully written.
+func saveCheckpoint(fName string, cB *starfish.CodeBox) error {
+	data, err := json.Marshal(cB.Snapshot())
+	if err != nil {
+		return err
+	}
+	tmp := fName + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fName)
+}
+
+// loadCheckpoint restores cB to the state saved in fName.
+func loadCheckpoint(fName string, cB *starfish.CodeBox) error {
+	data, err := ioutil.ReadFile(fName)
+	if err != nil {
+		return err
+	}
+	var s starfish.Snapshot
+	if err = json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	return cB.Restore(&s)
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/redstarcoder/go-starfish/starfish"
+)
+
+// debugJournal is how many ticks "back" can undo.
+const debugJournal = 100000
+
+// debugger is the REPL run by "starfish debug".
+type debugger struct {
+	name  string
+	cB    *starfish.CodeBox
+	w     io.Writer
+	ended bool
+	last  string // The last command, repeated by an empty line
+}
+
+// debugCommand is a command of the debugger.
+type debugCommand struct {
+	args string
+	help string
+	run  func(d *debugger, args []string) error
+}
+
+var debugCommands map[string]debugCommand
+
+func init() {
+	debugCommands = map[string]debugCommand{
+		"step":     {"[n]", "execute n ticks (default 1), ignoring breakpoints", (*debugger).step},
+		"back":     {"[n]", "undo n ticks (default 1)", (*debugger).back},
+		"continue": {"", "run until a breakpoint, the end, or an error (Ctrl-C pauses)", (*debugger).cont},
+		"break":    {"x,y [dir] | 'c' | len n | top v", "pause at a cell, an instruction, a stack length or a top value", (*debugger).addBreak},
+		"watch":    {"x,y | register", "pause after a cell is written or a register is filled", (*debugger).addWatch},
+		"delete":   {"id", "delete a breakpoint", (*debugger).deleteBreak},
+		"info":     {"", "list breakpoints", (*debugger).info},
+		"stack":    {"", "print the current stack", (*debugger).stack},
+		"stacks":   {"", "print every stack and register, including those saved by 'C'", (*debugger).stacks},
+		"box":      {"[rows]", "show the codebox around the fish (default 3 rows each way)", (*debugger).box},
+		"set":      {"x,y v", "write v to the cell at x,y", (*debugger).set},
+		"push":     {"v...", "push values onto the current stack", (*debugger).push},
+		"help":     {"", "list commands", (*debugger).help},
+		"quit":     {"", "exit the debugger", nil},
+	}
+}
+
+// debugAliases are short names for common commands, as in gdb.
+var debugAliases = map[string]string{"s": "step", "c": "continue", "b": "break", "q": "quit", "p": "stack"}
+
+// newDebugger returns a debugger for cB, which writes to w.
+func newDebugger(name string, cB *starfish.CodeBox, w io.Writer) *debugger {
+	return &debugger{name: name, cB: cB, w: w}
+}
+
+// repl reads commands from r until it ends or "quit" is entered. If r is a terminal, commands can be recalled
+// with the up and down arrows and completed with tab.
+func (d *debugger) repl(r io.Reader) {
+	fmt.Fprintln(d.w, "Debugging", d.name+". Type 'help' for a list of commands.")
+	d.where()
+	var readLine func() (string, error)
+	if f, ok := r.(*os.File); ok && isTerminal(f) {
+		pr := &prompt{r: bufio.NewReader(r), w: d.w, complete: completeCommand}
+		readLine = func() (string, error) {
+			return pr.readLine("(fish) ")
+		}
+	} else {
+		sc := bufio.NewScanner(r)
+		readLine = func() (string, error) {
+			fmt.Fprint(d.w, "(fish) ")
+			if !sc.Scan() {
+				fmt.Fprintln(d.w)
+				return "", io.EOF
+			}
+			return sc.Text(), nil
+		}
+	}
+	for {
+		line, err := readLine()
+		if err != nil {
+			if err != io.EOF {
+				fmt.Fprintln(d.w, err)
+			}
+			return
+		}
+		if d.exec(line) {
+			return
+		}
+	}
+}
+
+// completeCommand returns the lines line can be completed to: the commands its first word is a prefix of, and
+// for "watch" and "break", however they're abbreviated, the words their arguments can be.
+func completeCommand(line string) []string {
+	fields := strings.Fields(line)
+	if strings.HasSuffix(line, " ") || line == "" {
+		fields = append(fields, "")
+	}
+	var words []string
+	name, _ := lookupCommand(fields[0])
+	switch {
+	case len(fields) == 1:
+		for name := range debugCommands {
+			words = append(words, name)
+		}
+	case len(fields) == 2 && name == "watch":
+		words = []string{"register"}
+	case len(fields) == 3 && name == "break":
+		for name := range directions {
+			words = append(words, name)
+		}
+	}
+	last := fields[len(fields)-1]
+	prefix := strings.Join(fields[:len(fields)-1], " ")
+	if prefix != "" {
+		prefix += " "
+	}
+	var lines []string
+	for _, w := range words {
+		if strings.HasPrefix(w, last) {
+			lines = append(lines, prefix+w)
+		}
+	}
+	return lines
+}
+
+// exec runs one line of input, and returns true if it was "quit". An empty line repeats the last command.
+func (d *debugger) exec(line string) (quit bool) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		if fields = strings.Fields(d.last); len(fields) == 0 {
+			return false
+		}
+	}
+	name, err := lookupCommand(fields[0])
+	if err != nil {
+		fmt.Fprintln(d.w, err)
+		return false
+	}
+	if name == "quit" {
+		return true
+	}
+	d.last = strings.Join(fields, " ")
+	if err = debugCommands[name].run(d, fields[1:]); err != nil {
+		fmt.Fprintln(d.w, err)
+	}
+	d.cB.Flush()
+	return false
+}
+
+// lookupCommand returns the command named by s, which may be an alias or a unique prefix.
+func lookupCommand(s string) (string, error) {
+	if _, ok := debugCommands[s]; ok {
+		return s, nil
+	}
+	if name, ok := debugAliases[s]; ok {
+		return name, nil
+	}
+	var found []string
+	for name := range debugCommands {
+		if strings.HasPrefix(name, s) {
+			found = append(found, name)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return "", fmt.Errorf("unknown command %q, try 'help'", s)
+	case 1:
+		return found[0], nil
+	}
+	sort.Strings(found)
+	return "", fmt.Errorf("%q is ambiguous: %s", s, strings.Join(found, ", "))
+}
+
+// count parses an optional count argument.
+func count(args []string) (int64, error) {
+	if len(args) == 0 {
+		return 1, nil
+	}
+	n, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("invalid count %q", args[0])
+	}
+	return n, nil
+}
+
+// parseCoords parses "x,y".
+func parseCoords(s string) (x, y int, err error) {
+	parts := strings.Split(s, ",")
+	if len(parts) == 2 {
+		if x, err = strconv.Atoi(parts[0]); err == nil {
+			if y, err = strconv.Atoi(parts[1]); err == nil {
+				return x, y, nil
+			}
+		}
+	}
+	return 0, 0, fmt.Errorf("invalid coordinates %q, expected x,y", s)
+}
+
+// parseValue parses a number of any size, or a character in quotes.
+func (d *debugger) parseValue(s string) (starfish.Value, error) {
+	if r := []rune(s); len(r) == 3 && (r[0] == '\'' || r[0] == '"') && r[2] == r[0] {
+		return d.cB.Numeric().FromInt(int64(r[1])), nil
+	}
+	v, err := parseNumber([]rune(s))
+	if err != nil {
+		return v, fmt.Errorf("invalid value %q", s)
+	}
+	return d.cB.Numeric().Convert(v), nil
+}
+
+// errEnded is returned by commands that need the ><> to still be swimming.
+var errEnded = errors.New("the ><> has ended")
+
+func (d *debugger) step(args []string) error {
+	n, err := count(args)
+	if err != nil {
+		return err
+	}
+	for ; n > 0; n-- {
+		if d.ended {
+			return errEnded
+		}
+		end, err := d.cB.Swim()
+		if err != nil {
+			d.failed(err)
+			break
+		}
+		d.ended = end
+	}
+	d.where()
+	return nil
+}
+
+func (d *debugger) back(args []string) error {
+	n, err := count(args)
+	if err != nil {
+		return err
+	}
+	for ; n > 0; n-- {
+		if !d.cB.StepBack() {
+			fmt.Fprintln(d.w, "no more ticks to undo")
+			break
+		}
+		d.ended = false
+	}
+	d.where()
+	return nil
+}
+
+func (d *debugger) cont(args []string) error {
+	if d.ended {
+		return errEnded
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+	go func() {
+		select {
+		case <-sigs:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	res, err := d.cB.Run(ctx, starfish.RunOptions{})
+	switch res.Reason {
+	case starfish.Ended:
+		d.ended = true
+	case starfish.Paused:
+		fmt.Fprintf(d.w, "breakpoint %d, %v\n", res.Breakpoint.ID, res.Breakpoint)
+	case starfish.Cancelled:
+		fmt.Fprintln(d.w, "interrupted")
+	case starfish.Failed:
+		d.failed(err)
+	}
+	d.where()
+	return nil
+}
+
+// directions are the names of each Direction, as written by Direction.String.
+var directions = map[string]starfish.Direction{
+	"right": starfish.Right, "down": starfish.Down, "left": starfish.Left, "up": starfish.Up,
+}
+
+func (d *debugger) addBreak(args []string) error {
+	var b starfish.Breakpoint
+	switch {
+	case len(args) == 1 && len(args[0]) == 3 && args[0][0] == '\'' && args[0][2] == '\'':
+		b = starfish.OnInstruction(args[0][1])
+	case len(args) == 2 && args[0] == "len":
+		n, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("invalid length %q", args[1])
+		}
+		b = starfish.WhenStackLength(n)
+	case len(args) == 2 && args[0] == "top":
+		v, err := d.parseValue(args[1])
+		if err != nil {
+			return err
+		}
+		b = starfish.WhenTop(v)
+	case len(args) == 1 || len(args) == 2:
+		x, y, err := parseCoords(args[0])
+		if err != nil {
+			return err
+		}
+		b = starfish.AtCell(x, y)
+		if len(args) == 2 {
+			dir, ok := directions[args[1]]
+			if !ok {
+				return fmt.Errorf("unknown direction %q", args[1])
+			}
+			b = starfish.AtCellFacing(x, y, dir)
+		}
+	default:
+		return errors.New("usage: break " + debugCommands["break"].args)
+	}
+	fmt.Fprintf(d.w, "breakpoint %d, %v\n", d.cB.AddBreakpoint(b), b)
+	return nil
+}
+
+func (d *debugger) addWatch(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: watch " + debugCommands["watch"].args)
+	}
+	b := starfish.WatchRegister()
+	if args[0] != "register" {
+		x, y, err := parseCoords(args[0])
+		if err != nil {
+			return err
+		}
+		b = starfish.WatchCell(x, y)
+	}
+	fmt.Fprintf(d.w, "watchpoint %d, %v\n", d.cB.AddBreakpoint(b), b)
+	return nil
+}
+
+func (d *debugger) deleteBreak(args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: delete id")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil || !d.cB.RemoveBreakpoint(id) {
+		return fmt.Errorf("no breakpoint %s", args[0])
+	}
+	return nil
+}
+
+func (d *debugger) info(args []string) error {
+	bs := d.cB.Breakpoints()
+	if len(bs) == 0 {
+		fmt.Fprintln(d.w, "no breakpoints")
+	}
+	for _, b := range bs {
+		fmt.Fprintf(d.w, "%3d  %v\n", b.ID, b)
+	}
+	return nil
+}
+
+func (d *debugger) stack(args []string) error {
+	fmt.Fprintln(d.w, d.cB.Values())
+	return nil
+}
+
+func (d *debugger) stacks(args []string) error {
+	s := d.cB.Snapshot()
+	for i := len(s.Stacks) - 1; i >= 0; i-- {
+		mark := " "
+		if i == s.P {
+			mark = "*"
+		}
+		fmt.Fprintf(d.w, "%s%2d  %v", mark, i, s.Stacks[i].Values)
+		if r := s.Stacks[i].Register; r != nil {
+			fmt.Fprintf(d.w, "  register %v", *r)
+		}
+		fmt.Fprintln(d.w)
+	}
+	return nil
+}
+
+func (d *debugger) box(args []string) error {
+	rows := 3
+	if len(args) > 0 {
+		n, err := strconv.Atoi(args[0])
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid row count %q", args[0])
+		}
+		rows = n
+	}
+	x, y := d.cB.Loc()
+	printRegion(d.w, d.cB, x, y, rows)
+	return nil
+}
+
+func (d *debugger) set(args []string) error {
+	if len(args) != 2 {
+		return errors.New("usage: set x,y v")
+	}
+	x, y, err := parseCoords(args[0])
+	if err != nil {
+		return err
+	}
+	v, err := d.parseValue(args[1])
+	if err != nil {
+		return err
+	}
+	d.cB.SetCell(x, y, v)
+	return nil
+}
+
+func (d *debugger) push(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: push v...")
+	}
+	values := make([]starfish.Value, len(args))
+	for i, arg := range args {
+		v, err := d.parseValue(arg)
+		if err != nil {
+			return err
+		}
+		values[i] = v
+	}
+	for _, v := range values {
+		d.cB.Push(v)
+	}
+	return nil
+}
+
+func (d *debugger) help(args []string) error {
+	names := make([]string, 0, len(debugCommands))
+	for name := range debugCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := debugCommands[name]
+		fmt.Fprintf(d.w, "  %-8s %-32s %s\n", name, c.args, c.help)
+	}
+	fmt.Fprintln(d.w, "Commands can be shortened to any unique prefix, and an empty line repeats the last command.")
+	fmt.Fprintln(d.w, "At a terminal, the up and down arrows recall earlier commands, and tab completes them.")
+	return nil
+}
+
+// failed reports err, and undoes whatever the failed tick did before failing so it can be fixed and retried.
+func (d *debugger) failed(err error) {
+	diagnose(d.name, d.cB, err)
+	d.cB.StepBack()
+}
+
+// where prints the tick, where the fish is, and the codebox around it.
+func (d *debugger) where() {
+	d.cB.Flush()
+	x, y := d.cB.Loc()
+	state := ""
+	if d.ended {
+		state = " (ended)"
+	}
+	fmt.Fprintf(d.w, "tick %d at %d,%d swimming %v%s\n", d.cB.Tick(), x, y, d.cB.Dir(), state)
+	printRegion(d.w, d.cB, x, y, 1)
+}
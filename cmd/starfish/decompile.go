@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/redstarcoder/go-starfish/starfish"
+)
+
+// decompile implements "starfish decompile", writing the file named in args to stdout as pseudocode. It returns
+// the exit code.
+func decompile(args []string) int {
+	flags := flag.NewFlagSet("decompile", flag.ExitOnError)
+	stack := flags.Int("stack", 0, "how many values are on the stack when the script starts")
+	useUTF8 := flags.Bool("utf8", false, "read the script as UTF-8 instead of bytes")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage:", fName, "decompile [args] <file>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return 2
+	}
+
+	b, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	opts := starfish.LintOptions{Stack: *stack}
+	if *useUTF8 {
+		opts.Encoding = starfish.UTF8
+	}
+	fmt.Print(starfish.Decompile(string(b), opts))
+	return 0
+}
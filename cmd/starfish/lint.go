@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/redstarcoder/go-starfish/starfish"
+)
+
+// fileDiagnostic is a diagnostic in the JSON output of "starfish lint".
+type fileDiagnostic struct {
+	File string
+	starfish.Diagnostic
+}
+
+// lint implements "starfish lint", checking each file named in args. It returns the exit code: 1 if any file
+// has errors, and 2 if a file can't be read.
+func lint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "output the diagnostics as a JSON array")
+	plain := flags.Bool("plain", false, "report *><> instructions, for scripts meant for plain ><>")
+	stack := flags.Int("stack", -1, "also check for stack underflows, starting with this many values on the stack")
+	useUTF8 := flags.Bool("utf8", false, "read the scripts as UTF-8 instead of bytes")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage:", fName, "lint [args] <file>...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	opts := starfish.LintOptions{Plain: *plain}
+	if *useUTF8 {
+		opts.Encoding = starfish.UTF8
+	}
+	if *stack >= 0 {
+		opts.CheckStack, opts.Stack = true, *stack
+	}
+	code := 0
+	all := []fileDiagnostic{}
+	for _, name := range flags.Args() {
+		b, err := ioutil.ReadFile(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			code = 2
+			continue
+		}
+		for _, d := range starfish.Lint(string(b), opts) {
+			if d.Severity == starfish.Error && code == 0 {
+				code = 1
+			}
+			if *asJSON {
+				all = append(all, fileDiagnostic{name, d})
+			} else {
+				fmt.Printf("%s:%v\n", name, d)
+			}
+		}
+	}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		enc.Encode(all)
+	}
+	return code
+}
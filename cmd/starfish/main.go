@@ -1,11 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"github.com/redstarcoder/go-starfish/starfish"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -16,12 +22,31 @@ var (
 	help         *bool = flag.Bool("h", false, "display this help message")
 	delay              = flag.Duration("t", 0, "time to sleep between ticks (ex: 100ms)")
 	compmode           = flag.Bool("m", false, "run like the fishlanguage.com interpreter")
-	initialstack       = &stack{[]float64{}}
+	fileroot           = flag.String("root", "", "only let 'F' access files inside this directory")
+	nofiles            = flag.Bool("nofiles", false, "disable the 'F' instruction")
+	seed               = flag.Int64("seed", 0, "seed for the 'x' instruction (default random)")
+	utf8               = flag.Bool("utf8", false, "read the script and input as UTF-8 instead of bytes")
+	rawout             = flag.Bool("rawout", false, "write 'o' values as raw bytes instead of UTF-8")
+	faketime           = flag.String("fake-time", "", "use a fake clock starting at this RFC 3339 time, so 'S' doesn't sleep")
+	numeric            = flag.String("num", "float64", "arithmetic to use: float64, int64 or rational")
+	inputmode          = flag.String("input", "auto", "how 'i' waits for input: block, poll, or auto to block unless stdin is a terminal")
+	checkpoint         = flag.String("checkpoint", "", "save the program's state to this file when interrupted")
+	resume             = flag.String("resume", "", "resume the program saved in this checkpoint file")
+	infile             = flag.String("in", "", "read the program's input from this file instead of stdin")
+	profilefile        = flag.String("profile", "", "profile the program, print a heatmap of the codebox to stderr, and write an HTML report to this file")
+	tracefile          = flag.String("trace", "", "write a JSON line describing each tick to this file")
+	visual             = flag.Bool("v", false, "show the program running full-screen (space pauses, n steps, +/- change speed, q quits)")
+	initialstack       = &stack{[]starfish.Value{}}
 	fName              = "fish"
 )
 
 func Error() {
 	fmt.Println("Usage:", fName, "[args] <file>")
+	fmt.Println("      ", fName, "debug [args] <file>")
+	fmt.Println("      ", fName, "lint [-json] [-plain] [-stack n] [-utf8] <file>...")
+	fmt.Println("      ", fName, "cfg [-utf8] <file>")
+	fmt.Println("      ", fName, "decompile [-stack n] [-utf8] <file>")
+	fmt.Println("      ", fName, "build [-o prog] [-lib dir] [-utf8] [-num n] [-m] <file>")
 	flag.PrintDefaults()
 }
 
@@ -38,57 +63,233 @@ func loadScript(fName string) string {
 	return string(b)
 }
 
+// isTerminal returns true if f is a character device, such as a terminal.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// diagnose prints err to stderr. A *starfish.RuntimeError is shown as an excerpt of the codebox with a caret
+// under the failing cell. It returns the exit code the program should use.
+func diagnose(name string, cB *starfish.CodeBox, err error) int {
+	rErr, ok := err.(*starfish.RuntimeError)
+	if !ok {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "%s:%d:%d: %v\n", name, rErr.Y+1, rErr.X+1, rErr)
+	printRegion(os.Stderr, cB, rErr.X, rErr.Y, 1)
+	fmt.Fprintln(os.Stderr, "Stack:", rErr.Stack)
+	fmt.Fprintln(os.Stderr, "something smells fishy...")
+	return 1
+}
+
+// regionWidth is how many columns printRegion shows at most.
+const regionWidth = 120
+
+// printRegion prints the lines of the codebox within rows of y, with a caret under the cell at x, y. Only the
+// cells shown are read, so a codebox "p" has grown far doesn't make it slow. Lines longer than regionWidth are
+// cut around x.
+func printRegion(w io.Writer, cB *starfish.CodeBox, x, y, rows int) {
+	minX, minY, maxX, maxY := cB.Bounds()
+	left := minX
+	if x-left >= regionWidth-regionWidth/4 {
+		left = x - regionWidth/2
+	}
+	right := maxX
+	if right-left >= regionWidth {
+		right = left + regionWidth - 1
+	}
+	width := len(fmt.Sprint(maxY + 1))
+	line := make([]rune, right-left+1)
+	for row := y - rows; row <= y+rows; row++ {
+		if row < minY || row > maxY {
+			continue
+		}
+		for i := range line {
+			line[i] = starfish.CellRune(cB.Cell(left+i, row))
+		}
+		fmt.Fprintf(w, " %*d | %s\n", width, row+1, strings.TrimRight(string(line), " "))
+		if row == y {
+			fmt.Fprintf(w, " %*s | %s^\n", width, "", strings.Repeat(" ", x-left))
+		}
+	}
+}
+
 func init() {
 	fName = os.Args[0]
 	flag.Var(initialstack, "i", "set the initial stack (ex: '\"Example\" 10 \"stack\"')")
 }
 
 func main() {
-	flag.Parse()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "lint":
+			os.Exit(lint(os.Args[2:]))
+		case "cfg":
+			os.Exit(cfg(os.Args[2:]))
+		case "decompile":
+			os.Exit(decompile(os.Args[2:]))
+		case "build":
+			os.Exit(build(os.Args[2:]))
+		}
+	}
+	debugging := len(os.Args) > 1 && os.Args[1] == "debug"
+	if debugging {
+		flag.CommandLine.Parse(os.Args[2:])
+	} else {
+		flag.Parse()
+	}
 	args := flag.Args()
-	if *help || (*flagscript == "" && len(args) == 0) {
+	if *help || (*flagscript == "" && len(args) == 0 && *resume == "") {
 		Error()
 		return
 	}
+	if debugging {
+		name, cB := newCodeBox(args, true, starfish.WithJournal(debugJournal))
+		newDebugger(name, cB, os.Stdout).repl(os.Stdin)
+		exit(0)
+	}
+	if *visual {
+		out := new(tailBuffer)
+		name, cB := newCodeBox(args, true, starfish.WithOutput(out))
+		exit(newVisualizer(name, cB, out).run())
+	}
+	var extra []starfish.Option
+	if *profilefile != "" {
+		extra = append(extra, starfish.WithProfile())
+	}
+	name, cB := newCodeBox(args, false, extra...)
+
+	ctx := context.Background()
+	if *checkpoint != "" {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		sigs := make(chan os.Signal, 1)
+		signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-sigs
+			cancel()
+		}()
+	}
+	opts := starfish.RunOptions{Delay: *delay}
+	if *showcodebox || *showstack {
+		opts.Tick = printTick
+		printTick(cB)
+		time.Sleep(*delay)
+	}
+	res, err := cB.Run(ctx, opts)
+	if *profilefile != "" {
+		reportProfile(name, cB)
+	}
+	if res.Reason == starfish.Cancelled && *checkpoint != "" {
+		if err = saveCheckpoint(*checkpoint, cB); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			exit(1)
+		}
+		fmt.Fprintln(os.Stderr, "saved checkpoint to", *checkpoint)
+		exit(130)
+	}
+	if err != nil {
+		exit(diagnose(name, cB, err))
+	}
+	exit(0)
+}
+
+// newCodeBox creates the CodeBox described by the flags, args and extra options, and returns it with the name
+// to use for it in messages. When interactive, stdin is left for the user, so the program only gets input from
+// "-in".
+func newCodeBox(args []string, interactive bool, extra ...starfish.Option) (string, *starfish.CodeBox) {
 	var script string
-	if script = *flagscript; script == "" {
-		script = loadScript(args[0])
+	name := "code"
+	if script = *flagscript; script == "" && len(args) > 0 {
+		name = args[0]
+		script = loadScript(name)
+	} else if script == "" {
+		name = *resume
+		script = ";" // Replaced by the checkpoint
 	}
 
-	cB := starfish.NewCodeBox(script, initialstack.s, *compmode)
-	if !*showcodebox && !*showstack && *delay == 0 {
-		var (
-			end    bool
-			output string
-		)
-		for ; !end; output, end = cB.Swim() {
-			if output != "" {
-				fmt.Print(output)
-			}
+	var input io.Reader = os.Stdin
+	if *infile != "" {
+		f, err := os.Open(*infile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
 		}
-		return
+		input = f
+	} else if interactive {
+		input = strings.NewReader("")
 	}
-	if *showcodebox {
-		cB.PrintBox()
+	cBOpts := []starfish.Option{starfish.WithInput(input), starfish.WithOutput(os.Stdout)}
+	if *nofiles {
+		cBOpts = append(cBOpts, starfish.WithFileSystem(nil))
+	} else if *fileroot != "" {
+		cBOpts = append(cBOpts, starfish.WithFileSystem(starfish.DirFS(*fileroot)))
 	}
-	if *showstack && cB.StackLength() > 0 {
-		fmt.Println("Stack:", cB.Stack())
-	}
-	time.Sleep(*delay)
-	var (
-		end    bool
-		output string
-	)
-	for ; !end; output, end = cB.Swim() {
-		if output != "" {
-			fmt.Print(output)
+	if *utf8 {
+		cBOpts = append(cBOpts, starfish.WithScriptEncoding(starfish.UTF8), starfish.WithInputEncoding(starfish.UTF8))
+	}
+	if *rawout {
+		cBOpts = append(cBOpts, starfish.WithOutputEncoding(starfish.Bytes))
+	}
+	switch *inputmode {
+	case "auto":
+		if f, ok := input.(*os.File); !ok || !isTerminal(f) {
+			cBOpts = append(cBOpts, starfish.WithInputMode(starfish.Blocking))
 		}
-		if *showcodebox {
-			cB.PrintBox()
+	case "block":
+		cBOpts = append(cBOpts, starfish.WithInputMode(starfish.Blocking))
+	case "poll":
+	default:
+		fmt.Fprintln(os.Stderr, "unknown -input:", *inputmode)
+		os.Exit(2)
+	}
+	switch *numeric {
+	case "float64":
+	case "int64":
+		cBOpts = append(cBOpts, starfish.WithNumeric(starfish.Int64Numeric{}))
+	case "rational":
+		cBOpts = append(cBOpts, starfish.WithNumeric(starfish.RationalNumeric{}))
+	default:
+		fmt.Fprintln(os.Stderr, "unknown -num:", *numeric)
+		os.Exit(2)
+	}
+	cBOpts = append(cBOpts, starfish.WithStack(initialstack.s))
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "seed" {
+			cBOpts = append(cBOpts, starfish.WithRandSource(rand.NewSource(*seed)))
 		}
-		if *showstack && cB.StackLength() > 0 {
-			fmt.Println("Stack:", cB.Stack())
+	})
+	if *faketime != "" {
+		t, err := time.Parse(time.RFC3339, *faketime)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
 		}
-		time.Sleep(*delay)
+		cBOpts = append(cBOpts, starfish.WithClock(starfish.NewFakeClock(t)))
+	}
+	if *tracefile != "" {
+		cBOpts = append(cBOpts, openTrace(*tracefile))
+	}
+	cBOpts = append(cBOpts, extra...)
+	cB := starfish.NewCodeBox(script, nil, *compmode, cBOpts...)
+	if *resume != "" {
+		if err := loadCheckpoint(*resume, cB); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+	return name, cB
+}
+
+// printTick outputs the codebox and/or stack, as requested by the "-c" and "-s" flags.
+func printTick(cB *starfish.CodeBox) {
+	cB.Flush()
+	if *showcodebox {
+		cB.PrintBox()
+	}
+	if *showstack && cB.StackLength() > 0 {
+		fmt.Println("Stack:", cB.Values())
 	}
 }
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/redstarcoder/go-starfish/starfish"
+)
+
+// heatColors are the 256-colour backgrounds of the terminal heatmap, from the coldest cell to the hottest.
+var heatColors = []int{17, 19, 21, 27, 33, 39, 45, 51, 50, 48, 46, 82, 118, 154, 190, 226, 220, 214, 208, 202, 196}
+
+const (
+	heatmapGap  = 8   // Empty columns or rows in a row that heatmaps show as one gap instead
+	heatmapSize = 256 // The most columns or rows heatmaps show
+)
+
+// heatmap is a profile laid out over the cells of the codebox that hold something or executed. Empty columns
+// and rows between them are left out where there are more than heatmapGap of them in a row, so cells "p" wrote
+// far away don't make it huge, and it's cut off after heatmapSize columns or rows.
+type heatmap struct {
+	cells      map[[2]int]starfish.Value
+	counts     map[[2]int]int64
+	max        int64
+	cols, rows []heatLine
+}
+
+// heatLine is a column or row of a heatmap: the one at coordinate at, or a gap standing for any left out.
+type heatLine struct {
+	at  int
+	gap bool
+}
+
+func newHeatmap(cB *starfish.CodeBox, prof *starfish.Profile) *heatmap {
+	stored := cB.Snapshot().Cells
+	h := &heatmap{
+		cells:  make(map[[2]int]starfish.Value, len(stored)),
+		counts: make(map[[2]int]int64, len(prof.Cells)),
+	}
+	xs, ys := make(map[int]bool), make(map[int]bool)
+	for _, c := range stored {
+		h.cells[[2]int{c.X, c.Y}] = c.Value
+		xs[c.X], ys[c.Y] = true, true
+	}
+	for _, c := range prof.Cells {
+		h.counts[[2]int{c.X, c.Y}] = c.Count
+		xs[c.X], ys[c.Y] = true, true
+		if c.Count > h.max {
+			h.max = c.Count
+		}
+	}
+	h.cols, h.rows = heatLines(xs), heatLines(ys)
+	return h
+}
+
+// heatLines returns the columns or rows of a heatmap showing the coordinates in used.
+func heatLines(used map[int]bool) []heatLine {
+	at := make([]int, 0, len(used))
+	for i := range used {
+		at = append(at, i)
+	}
+	sort.Ints(at)
+	var lines []heatLine
+	for i, n := range at {
+		if i > 0 && n-at[i-1] > heatmapGap+1 {
+			lines = append(lines, heatLine{gap: true})
+		} else if i > 0 {
+			for m := at[i-1] + 1; m < n; m++ {
+				lines = append(lines, heatLine{at: m})
+			}
+		}
+		if len(lines) >= heatmapSize {
+			return append(lines[:heatmapSize], heatLine{gap: true})
+		}
+		lines = append(lines, heatLine{at: n})
+	}
+	return lines
+}
+
+// char returns the character shown for the cell at col, row.
+func (h *heatmap) char(col, row heatLine) rune {
+	switch {
+	case row.gap:
+		return '⋮'
+	case col.gap:
+		return '…'
+	}
+	return starfish.CellRune(h.cells[[2]int{col.at, row.at}])
+}
+
+// heat returns how hot the cell at x, y is, from 0 for the coldest cell that executed to 1 for the hottest. The
+// scale is logarithmic, so cells executed a few times don't all look cold next to a hot loop. The bool is false if
+// the cell never executed.
+func (h *heatmap) heat(x, y int) (float64, bool) {
+	n := h.counts[[2]int{x, y}]
+	if n == 0 {
+		return 0, false
+	}
+	if h.max == 1 {
+		return 1, true
+	}
+	return math.Log(float64(n)) / math.Log(float64(h.max)), true
+}
+
+// print writes the heatmap to w with ANSI colours.
+func (h *heatmap) print(w io.Writer) {
+	for _, row := range h.rows {
+		for _, col := range h.cols {
+			r := h.char(col, row)
+			if heat, ok := h.heat(col.at, row.at); ok && !col.gap && !row.gap {
+				fmt.Fprintf(w, "\x1b[30;48;5;%dm%c\x1b[0m", heatColors[int(heat*float64(len(heatColors)-1))], r)
+			} else {
+				fmt.Fprintf(w, "%c", r)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+}
+
+// profileOp is an instruction's row in a profile report.
+type profileOp struct {
+	Op      string
+	Count   int64
+	Percent float64
+	Time    time.Duration
+}
+
+// profileOps returns the instructions of prof with string mode pushes, from the most executed down.
+func profileOps(prof *starfish.Profile) []profileOp {
+	var ops []profileOp
+	add := func(op string, o starfish.ProfileOp) {
+		if o.Count > 0 && prof.Ticks > 0 {
+			ops = append(ops, profileOp{op, o.Count, 100 * float64(o.Count) / float64(prof.Ticks), o.Time})
+		}
+	}
+	for op, o := range prof.Instructions {
+		add(fmt.Sprintf("%q", op), o)
+	}
+	add("string mode", prof.Pushes)
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].Count > ops[j].Count || (ops[i].Count == ops[j].Count && ops[i].Op < ops[j].Op)
+	})
+	return ops
+}
+
+// hottest returns up to n of the cells of prof that executed most, from the hottest down.
+func hottest(prof *starfish.Profile, n int) []starfish.ProfileCell {
+	cells := append([]starfish.ProfileCell{}, prof.Cells...)
+	sort.SliceStable(cells, func(i, j int) bool {
+		return cells[i].Count > cells[j].Count
+	})
+	if len(cells) > n {
+		cells = cells[:n]
+	}
+	return cells
+}
+
+// printProfile writes the heatmap and a summary of prof to w.
+func printProfile(w io.Writer, cB *starfish.CodeBox, prof *starfish.Profile) {
+	newHeatmap(cB, prof).print(w)
+	fmt.Fprintf(w, "\n%d ticks in %v\n\n", prof.Ticks, prof.Time)
+	fmt.Fprintln(w, "instruction        count      %         time")
+	for _, o := range profileOps(prof) {
+		fmt.Fprintf(w, "%-13s %10d %6.2f %12v\n", o.Op, o.Count, o.Percent, o.Time)
+	}
+	fmt.Fprintln(w, "\nhottest cells:")
+	for _, c := range hottest(prof, 10) {
+		fmt.Fprintf(w, "  %d,%d %d\n", c.X, c.Y, c.Count)
+	}
+	fmt.Fprintln(w, "\nmost values on each stack, from the bottom stack up:", prof.MaxDepth)
+}
+
+// reportTemplate is the HTML profile report.
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Profile of {{.Name}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table.box { border-collapse: collapse; font-family: monospace; font-size: 16px; }
+table.box td { width: 1.2em; height: 1.4em; text-align: center; padding: 0; border: 1px solid #eee; }
+table.stats { border-collapse: collapse; margin-top: 1em; }
+table.stats td, table.stats th { padding: 0.2em 1em; text-align: right; border-bottom: 1px solid #ddd; }
+</style>
+</head>
+<body>
+<h1>Profile of {{.Name}}</h1>
+<p>{{.Prof.Ticks}} ticks in {{.Prof.Time}}. Hover over a cell to see how many times it executed. Runs of empty
+columns and rows are left out.</p>
+<table class="box">
+{{range .Rows}}<tr>{{range .}}{{if .Gap}}<td>{{.Char}}</td>{{else}}<td{{if .Count}} style="background: {{.Color}}"{{end}} title="{{.X}},{{.Y}}: {{.Count}}">{{.Char}}</td>{{end}}{{end}}</tr>
+{{end}}</table>
+<h2>Instructions</h2>
+<table class="stats">
+<tr><th>instruction</th><th>count</th><th>%</th><th>time</th></tr>
+{{range .Ops}}<tr><td>{{.Op}}</td><td>{{.Count}}</td><td>{{printf "%.2f" .Percent}}</td><td>{{.Time}}</td></tr>
+{{end}}</table>
+<h2>Hottest cells</h2>
+<table class="stats">
+<tr><th>cell</th><th>count</th></tr>
+{{range .Hottest}}<tr><td>{{.X}},{{.Y}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+<h2>Stacks</h2>
+<table class="stats">
+<tr><th>stack</th><th>most values</th></tr>
+{{range $i, $n := .Prof.MaxDepth}}<tr><td>{{$i}}</td><td>{{$n}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// reportCell is a cell of the codebox in the HTML report.
+type reportCell struct {
+	X, Y  int
+	Char  string
+	Count int64
+	Color template.CSS
+	Gap   bool // Set if the cell stands for columns or rows left out
+}
+
+// writeReport writes an HTML report of prof to fName.
+func writeReport(fName, name string, cB *starfish.CodeBox, prof *starfish.Profile) error {
+	h := newHeatmap(cB, prof)
+	rows := make([][]reportCell, len(h.rows))
+	for i, row := range h.rows {
+		rows[i] = make([]reportCell, len(h.cols))
+		for j, col := range h.cols {
+			c := reportCell{X: col.at, Y: row.at, Char: string(h.char(col, row)), Gap: col.gap || row.gap}
+			if heat, ok := h.heat(col.at, row.at); ok && !c.Gap {
+				c.Count = h.counts[[2]int{col.at, row.at}]
+				c.Color = template.CSS(fmt.Sprintf("hsl(%.0f, 90%%, 60%%)", 240*(1-heat))) // Blue to red
+			}
+			rows[i][j] = c
+		}
+	}
+
+	f, err := os.Create(fName)
+	if err != nil {
+		return err
+	}
+	err = reportTemplate.Execute(f, map[string]interface{}{
+		"Name":    name,
+		"Prof":    prof,
+		"Rows":    rows,
+		"Ops":     profileOps(prof),
+		"Hottest": hottest(prof, 20),
+	})
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	return err
+}
+
+// reportProfile prints the profile of cB to stderr and writes the HTML report requested by "-profile".
+func reportProfile(name string, cB *starfish.CodeBox) {
+	prof := cB.Profile()
+	fmt.Fprintln(os.Stderr)
+	printProfile(os.Stderr, cB, prof)
+	if err := writeReport(*profilefile, name, cB, prof); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return
+	}
+	fmt.Fprintln(os.Stderr, "wrote profile to", *profilefile)
+}
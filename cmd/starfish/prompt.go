@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// prompt reads lines typed at a terminal, with a history browsed with the up and down arrows, and completion
+// with tab. The terminal is only put in raw mode while a line is being read, so Ctrl-C still interrupts the
+// commands run between lines.
+type prompt struct {
+	r        *bufio.Reader
+	w        io.Writer
+	history  []string
+	complete func(line string) []string // Returns the lines line can be completed to
+}
+
+// readLine prints p and reads a line, which it adds to the history. Ctrl-C discards the line typed so far, and
+// Ctrl-D on an empty line ends the input, as does the end of r, returning io.EOF.
+func (pr *prompt) readLine(p string) (string, error) {
+	restore, err := rawTerminal("-isig")
+	if err != nil {
+		return "", err
+	}
+	defer restore()
+
+	var line []rune
+	hist := len(pr.history) // The line of history shown, or len(history) for the new line
+	draw := func() {
+		fmt.Fprintf(pr.w, "\r%s%s\x1b[K", p, string(line))
+	}
+	draw()
+	for {
+		r, _, err := pr.r.ReadRune()
+		if err != nil {
+			fmt.Fprintln(pr.w)
+			return "", io.EOF
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprintln(pr.w)
+			s := string(line)
+			if strings.TrimSpace(s) != "" && (len(pr.history) == 0 || pr.history[len(pr.history)-1] != s) {
+				pr.history = append(pr.history, s)
+			}
+			return s, nil
+		case 3: // Ctrl-C
+			fmt.Fprintln(pr.w, "^C")
+			line, hist = nil, len(pr.history)
+		case 4: // Ctrl-D
+			if len(line) == 0 {
+				fmt.Fprintln(pr.w)
+				return "", io.EOF
+			}
+		case 8, 127: // Backspace
+			if len(line) > 0 {
+				line = line[:len(line)-1]
+			}
+		case 21: // Ctrl-U
+			line = nil
+		case '\t':
+			line = pr.completeLine(p, line)
+		case 27: // An escape sequence, of which only the up and down arrows are used
+			if b, _ := pr.r.ReadByte(); b != '[' {
+				break
+			}
+			switch b, _ := pr.r.ReadByte(); {
+			case b == 'A' && hist > 0:
+				hist--
+				line = []rune(pr.history[hist])
+			case b == 'B' && hist < len(pr.history):
+				if hist++; hist == len(pr.history) {
+					line = nil
+				} else {
+					line = []rune(pr.history[hist])
+				}
+			}
+		default:
+			if unicode.IsPrint(r) {
+				line = append(line, r)
+			}
+		}
+		draw()
+	}
+}
+
+// completeLine returns line completed as far as it can be without choosing between completions. If it can't be
+// completed any further, the choices are listed below the prompt p.
+func (pr *prompt) completeLine(p string, line []rune) []rune {
+	if pr.complete == nil {
+		return line
+	}
+	choices := pr.complete(string(line))
+	if len(choices) == 0 {
+		return line
+	}
+	common := choices[0]
+	for _, c := range choices[1:] {
+		for !strings.HasPrefix(c, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(choices) == 1 {
+		common += " "
+	}
+	if len(common) > len(string(line)) {
+		return []rune(common)
+	}
+	sort.Strings(choices)
+	words := make([]string, len(choices))
+	for i, c := range choices {
+		words[i] = c[strings.LastIndexByte(c, ' ')+1:]
+	}
+	fmt.Fprintf(pr.w, "\r\n%s\n", strings.Join(words, "  "))
+	return line
+}
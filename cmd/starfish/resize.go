@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// resizeSignals are the signals sent when the terminal is resized.
+var resizeSignals = []os.Signal{syscall.SIGWINCH}
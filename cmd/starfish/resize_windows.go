@@ -0,0 +1,6 @@
+package main
+
+import "os"
+
+// resizeSignals are the signals sent when the terminal is resized. Windows has none.
+var resizeSignals []os.Signal
@@ -2,24 +2,35 @@ package main
 
 import (
 	"errors"
-	"strconv"
+	"math/big"
+
+	"github.com/redstarcoder/go-starfish/starfish"
 )
 
 type stack struct {
-	s []float64
+	s []starfish.Value
 }
 
 func (s *stack) String() string {
 	return ""
 }
 
+// parseNumber parses a number of any size or precision.
+func parseNumber(runes []rune) (starfish.Value, error) {
+	r, ok := new(big.Rat).SetString(string(runes))
+	if !ok {
+		return starfish.Value{}, errors.New("Invalid number in initial stack: " + string(runes))
+	}
+	return starfish.Rat(r), nil
+}
+
 func (s *stack) Set(str string) error {
 	var strMode byte
 	runes := make([]rune, 0, 32)
-	s.s = make([]float64, 0, 32)
+	s.s = make([]starfish.Value, 0, 32)
 	for _, r := range str {
 		if strMode != 0 && byte(r) != strMode {
-			s.s = append(s.s, float64(r))
+			s.s = append(s.s, starfish.Int(int64(r)))
 			continue
 		}
 		switch r {
@@ -27,8 +38,8 @@ func (s *stack) Set(str string) error {
 			return errors.New("Invalid initial stack")
 		case ' ':
 			if len(runes) > 0 {
-				if f, err := strconv.ParseFloat(string(runes), 64); err == nil {
-					s.s = append(s.s, f)
+				if v, err := parseNumber(runes); err == nil {
+					s.s = append(s.s, v)
 					runes = make([]rune, 0, 32)
 				} else {
 					return err
@@ -44,11 +55,12 @@ func (s *stack) Set(str string) error {
 			runes = append(runes, r)
 		}
 	}
-	if f, err := strconv.ParseFloat(string(runes), 64); err == nil {
-		s.s = append(s.s, f)
-		runes = make([]rune, 0, 32)
-	} else if len(runes) > 0 {
-		return err
+	if len(runes) > 0 {
+		v, err := parseNumber(runes)
+		if err != nil {
+			return err
+		}
+		s.s = append(s.s, v)
 	}
 	return nil
 }
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// stty runs stty on the terminal attached to stdin and returns what it prints.
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return strings.TrimSpace(string(out)), err
+}
+
+// rawTerminal makes stdin deliver each key as it's pressed, without echoing it, and applies any extra stty
+// settings. It returns a function that puts the terminal back the way it was.
+func rawTerminal(extra ...string) (restore func(), err error) {
+	state, err := stty("-g")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = stty(append([]string{"-icanon", "-echo", "min", "1"}, extra...)...); err != nil {
+		return nil, err
+	}
+	return func() {
+		stty(state)
+	}, nil
+}
+
+// terminalSize returns the number of rows and columns of the terminal, or 24 by 80 if it can't be found.
+func terminalSize() (rows, cols int) {
+	if out, err := stty("size"); err == nil {
+		if _, err = fmt.Sscan(out, &rows, &cols); err == nil && rows > 0 && cols > 0 {
+			return rows, cols
+		}
+	}
+	return 24, 80
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/redstarcoder/go-starfish/starfish"
+)
+
+// traceOut buffers the trace file given by "-trace", if any.
+var traceOut *bufio.Writer
+
+// openTrace creates fName and returns an option that writes a JSON line to it for every tick.
+func openTrace(fName string) starfish.Option {
+	f, err := os.Create(fName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	traceOut = bufio.NewWriter(f)
+	enc := json.NewEncoder(traceOut)
+	return starfish.WithTrace(func(r starfish.TraceRecord) {
+		if err := enc.Encode(r); err != nil {
+			fmt.Fprintln(os.Stderr, "writing trace:", err)
+			os.Exit(1)
+		}
+	})
+}
+
+// exit flushes the trace, if any, and exits with code.
+func exit(code int) {
+	if traceOut != nil {
+		if err := traceOut.Flush(); err != nil {
+			fmt.Fprintln(os.Stderr, "writing trace:", err)
+			code = 1
+		}
+	}
+	os.Exit(code)
+}
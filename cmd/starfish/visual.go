@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/redstarcoder/go-starfish/starfish"
+)
+
+const (
+	trailLength = 8                      // How many visited cells the trail shows
+	paneWidth   = 32                     // Width of the stacks pane
+	outputLines = 5                      // Height of the output pane
+	minDelay    = time.Millisecond       // Fastest speed
+	maxDelay    = 2 * time.Second        // Slowest speed
+	startDelay  = 100 * time.Millisecond // Speed used if "-t" isn't given
+)
+
+// tailBuffer keeps the last few KB written to it, for the output pane.
+type tailBuffer struct {
+	b []byte
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.b = append(t.b, p...)
+	if len(t.b) > 8192 {
+		t.b = append(t.b[:0], t.b[len(t.b)-4096:]...)
+	}
+	return len(p), nil
+}
+
+// point is the location of a cell.
+type point struct {
+	x, y int
+}
+
+// visualizer shows a CodeBox running full-screen.
+type visualizer struct {
+	name       string
+	cB         *starfish.CodeBox
+	out        *tailBuffer
+	w          *bufio.Writer
+	rows, cols int
+	offX, offY int     // The cell at the top-left of the viewport
+	trail      []point // Recently visited cells, oldest first
+	delay      time.Duration
+	paused     bool
+	ended      bool
+	err        error
+}
+
+// newVisualizer returns a visualizer for cB, whose output is written to out.
+func newVisualizer(name string, cB *starfish.CodeBox, out *tailBuffer) *visualizer {
+	v := &visualizer{name: name, cB: cB, out: out, w: bufio.NewWriter(os.Stdout), delay: *delay}
+	if v.delay <= 0 {
+		v.delay = startDelay
+	}
+	v.offX, v.offY, _, _ = cB.Bounds()
+	return v
+}
+
+// run shows the ><> until the user quits, and returns the exit code the program should use.
+func (v *visualizer) run() int {
+	restore, err := rawTerminal()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "can't read keys from the terminal:", err)
+		return 2
+	}
+	keys := make(chan byte)
+	go func() {
+		b := make([]byte, 1)
+		for {
+			if n, err := os.Stdin.Read(b); err != nil {
+				close(keys)
+				return
+			} else if n == 1 {
+				keys <- b[0]
+			}
+		}
+	}()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt)
+	defer signal.Stop(sigs)
+	resized := make(chan os.Signal, 1)
+	if len(resizeSignals) > 0 {
+		signal.Notify(resized, resizeSignals...)
+		defer signal.Stop(resized)
+	}
+
+	fmt.Fprint(v.w, "\x1b[?1049h\x1b[?25l") // Switch to the alternate screen and hide the cursor
+	v.loop(keys, sigs, resized)
+	fmt.Fprint(v.w, "\x1b[?25h\x1b[?1049l")
+	v.w.Flush()
+	restore()
+
+	if v.err != nil {
+		return diagnose(v.name, v.cB, v.err)
+	}
+	return 0
+}
+
+// loop ticks and redraws until the user quits. The size of the terminal is read again when resized receives.
+func (v *visualizer) loop(keys <-chan byte, sigs, resized <-chan os.Signal) {
+	v.rows, v.cols = terminalSize()
+	for {
+		v.render()
+		var timer <-chan time.Time
+		if !v.paused && !v.ended {
+			timer = time.After(v.delay)
+		}
+		select {
+		case <-sigs:
+			return
+		case <-resized:
+			v.rows, v.cols = terminalSize()
+		case <-timer:
+			v.tick()
+		case k, ok := <-keys:
+			if !ok {
+				return
+			}
+			switch k {
+			case 'q', 'Q':
+				return
+			case ' ', 'p':
+				v.paused = !v.paused
+			case 'n', 's', '.':
+				if v.paused && !v.ended {
+					v.tick()
+				}
+			case '+', '=':
+				if v.delay /= 2; v.delay < minDelay {
+					v.delay = minDelay
+				}
+			case '-', '_':
+				if v.delay *= 2; v.delay > maxDelay {
+					v.delay = maxDelay
+				}
+			}
+		}
+	}
+}
+
+// tick executes one tick, remembering where the fish was for the trail.
+func (v *visualizer) tick() {
+	x, y := v.cB.Loc()
+	end, err := v.cB.Swim()
+	v.cB.Flush()
+	if err != nil {
+		v.err, v.ended = err, true
+		return
+	}
+	v.ended = end
+	if v.trail = append(v.trail, point{x, y}); len(v.trail) > trailLength {
+		v.trail = v.trail[1:]
+	}
+}
+
+// follow scrolls the viewport, which is width by height cells, so the fish stays inside it.
+func (v *visualizer) follow(width, height int) {
+	x, y := v.cB.Loc()
+	minX, minY, maxX, maxY := v.cB.Bounds()
+	v.offX = scroll(v.offX, x, width, minX, maxX)
+	v.offY = scroll(v.offY, y, height, minY, maxY)
+}
+
+// scroll returns the offset of a viewport of size cells that shows pos, keeping a margin around it where the
+// codebox allows, given the current offset and the range the codebox covers.
+func scroll(off, pos, size, min, max int) int {
+	margin := size / 4
+	if margin > 4 {
+		margin = 4
+	}
+	if pos < off+margin {
+		off = pos - margin
+	} else if pos >= off+size-margin {
+		off = pos - size + margin + 1
+	}
+	if off > max-size+1 {
+		off = max - size + 1
+	}
+	if off < min {
+		off = min
+	}
+	return off
+}
+
+// ahead returns the cell in front of the fish.
+func (v *visualizer) ahead() point {
+	x, y := v.cB.Loc()
+	switch v.cB.Dir() {
+	case starfish.Right:
+		x++
+	case starfish.Down:
+		y++
+	case starfish.Left:
+		x--
+	case starfish.Up:
+		y--
+	}
+	return point{x, y}
+}
+
+// render redraws the whole screen.
+func (v *visualizer) render() {
+	codeWidth := v.cols - paneWidth - 1
+	codeHeight := v.rows - outputLines - 2
+	if codeWidth < 1 {
+		codeWidth = 1
+	}
+	if codeHeight < 1 {
+		codeHeight = 1
+	}
+	v.follow(codeWidth, codeHeight)
+	pane := v.pane(codeHeight)
+	fX, fY := v.cB.Loc()
+	ahead := v.ahead()
+	age := make(map[point]int, len(v.trail))
+	for i, p := range v.trail {
+		age[p] = len(v.trail) - i
+	}
+
+	fmt.Fprint(v.w, "\x1b[H")
+	for row := 0; row < codeHeight; row++ {
+		y := v.offY + row
+		for col := 0; col < codeWidth; col++ {
+			x := v.offX + col
+			r := starfish.CellRune(v.cB.Cell(x, y))
+			switch p := (point{x, y}); {
+			case x == fX && y == fY:
+				fmt.Fprintf(v.w, "\x1b[1;30;43m%c\x1b[0m", r)
+			case p == ahead:
+				fmt.Fprintf(v.w, "\x1b[4m%c\x1b[0m", r)
+			case age[p] > 0:
+				fmt.Fprintf(v.w, "\x1b[48;5;%dm%c\x1b[0m", 245-age[p], r) // Lighter greys are more recent
+			default:
+				v.w.WriteRune(r)
+			}
+		}
+		fmt.Fprintf(v.w, "\x1b[0m│%s\x1b[K\r\n", pane[row])
+	}
+	fmt.Fprintf(v.w, "%s\x1b[K\r\n", strings.Repeat("─", codeWidth)+"┴"+strings.Repeat("─", paneWidth))
+	for _, line := range v.output() {
+		fmt.Fprintf(v.w, "%s\x1b[K\r\n", line)
+	}
+	fmt.Fprintf(v.w, "\x1b[7m%s\x1b[K\x1b[0m", v.status())
+	v.w.Flush()
+}
+
+// arrows show the direction the fish is swimming.
+var arrows = map[starfish.Direction]string{starfish.Right: "→", starfish.Down: "↓", starfish.Left: "←", starfish.Up: "↑"}
+
+// status returns the status line.
+func (v *visualizer) status() string {
+	x, y := v.cB.Loc()
+	state := "running"
+	switch {
+	case v.err != nil:
+		state = "failed: " + v.err.Error()
+	case v.ended:
+		state = "ended"
+	case v.paused:
+		state = "paused"
+	}
+	line := []rune(fmt.Sprintf(" %s  tick %d  %d,%d %s  delay %v  %s  [space] pause [n] step [+/-] speed [q] quit",
+		v.name, v.cB.Tick(), x, y, arrows[v.cB.Dir()], v.delay, state))
+	if len(line) > v.cols {
+		line = line[:v.cols]
+	}
+	return string(line)
+}
+
+// pane returns the lines of the side pane, padded to height.
+func (v *visualizer) pane(height int) []string {
+	mode := "normal"
+	if q := v.cB.StringMode(); q != 0 {
+		mode = "string (" + string(rune(q)) + ")"
+	} else if v.cB.DeepSea() {
+		mode = "deep sea"
+	}
+	lines := []string{" mode: " + mode, " stacks (top on the right):"}
+	stacks, p := v.cB.Stacks()
+	for i := len(stacks) - 1; i >= 0; i-- {
+		mark := " "
+		if i == p {
+			mark = "*"
+		}
+		lines = append(lines, fitLeft(fmt.Sprintf("%s%d %v", mark, i, stacks[i].Values), paneWidth))
+		if r := stacks[i].Register; r != nil {
+			lines = append(lines, fitLeft(fmt.Sprintf("   register %v", *r), paneWidth))
+		}
+	}
+	for len(lines) < height {
+		lines = append(lines, "")
+	}
+	return lines[:height]
+}
+
+// fitLeft cuts s to width runes, dropping runes from the left so the end, such as the top of a stack, shows.
+func fitLeft(s string, width int) string {
+	if r := []rune(s); len(r) > width {
+		return "…" + string(r[len(r)-width+1:])
+	}
+	return s
+}
+
+// output returns the last lines of the program's output, with control characters replaced.
+func (v *visualizer) output() []string {
+	text := strings.Map(func(r rune) rune {
+		if r == '\n' || unicode.IsPrint(r) {
+			return r
+		}
+		return '·'
+	}, string(v.out.b))
+	lines := strings.Split(text, "\n")
+	if len(lines) > outputLines {
+		lines = lines[len(lines)-outputLines:]
+	}
+	for len(lines) < outputLines {
+		lines = append(lines, "")
+	}
+	for i, line := range lines {
+		if r := []rune(line); len(r) > v.cols {
+			lines[i] = string(r[len(r)-v.cols:])
+		}
+	}
+	return lines
+}
@@ -0,0 +1,217 @@
+package starfish
+
+import (
+	"sort"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
+)
+
+// point is the location of a cell in a codebox.
+type point struct {
+	x, y int
+}
+
+// cells holds the contents of a codebox. The rectangle the script was loaded into is stored densely, and any
+// cell "p" writes outside of it is kept in a sparse map. The bounds of the codebox grow to cover every cell
+// that has been written. Cells hold any number "p" writes to them; empty cells hold 0.
+type cells struct {
+	core                   [][]Value
+	width, height          int // Size of core
+	sparse                 map[point]Value
+	minX, minY, maxX, maxY int
+
+	// Predecoded copies of core, kept up to date by predecode and decode
+	ops  []byte  // The instruction each cell executes as, row by row, or noOp
+	runs []int32 // For each cell and Direction, how many cells in a row from it hold plain instructions
+}
+
+// emptyCells returns cells with a core of width by height empty cells. predecode must be called once core has
+// been filled in.
+func emptyCells(width, height int) cells {
+	c := cells{width: width, height: height, ops: make([]byte, width*height), runs: make([]int32, width*height*4)}
+	c.core = make([][]Value, height)
+	for i := range c.core {
+		c.core[i] = make([]Value, width)
+	}
+	c.maxX, c.maxY = width-1, height-1
+	return c
+}
+
+// newCells returns cells holding lines, padded with empty cells to a rectangle. Lines are split into cells as
+// selected by e, and characters are stored as Values made by num.
+func newCells(lines []string, e Encoding, num Numeric) cells {
+	decoded := make([][]rune, len(lines))
+	for i, s := range lines {
+		if e == UTF8 {
+			decoded[i] = []rune(s)
+			continue
+		}
+		decoded[i] = make([]rune, len(s))
+		for ii := 0; ii < len(s); ii++ {
+			decoded[i][ii] = rune(s[ii])
+		}
+	}
+
+	c := emptyCells(longestLineLength(decoded), len(lines))
+	for i, s := range decoded {
+		for ii, r := range s {
+			c.core[i][ii] = num.FromInt(int64(r))
+		}
+	}
+	c.predecode()
+	return c
+}
+
+// inCore returns true if x, y is inside core.
+func (c *cells) inCore(x, y int) bool {
+	return x >= 0 && y >= 0 && x < c.width && y < c.height
+}
+
+// get returns the cell at x, y.
+func (c *cells) get(x, y int) Value {
+	if c.inCore(x, y) {
+		return c.core[y][x]
+	}
+	return c.sparse[point{x, y}]
+}
+
+// op returns the instruction the cell at x, y executes as, as opcode does.
+func (c *cells) op(x, y int) (byte, bool) {
+	if c.inCore(x, y) {
+		op := c.ops[y*c.width+x]
+		return op, op != noOp
+	}
+	return opcode(c.sparse[point{x, y}])
+}
+
+// set writes v to the cell at x, y, growing the bounds of the codebox if needed.
+func (c *cells) set(x, y int, v Value) {
+	if c.inCore(x, y) {
+		c.core[y][x] = v
+		c.decode(x, y)
+		return
+	}
+	if c.sparse == nil {
+		c.sparse = make(map[point]Value)
+	}
+	c.sparse[point{x, y}] = v
+	if x < c.minX {
+		c.minX = x
+	} else if x > c.maxX {
+		c.maxX = x
+	}
+	if y < c.minY {
+		c.minY = y
+	} else if y > c.maxY {
+		c.maxY = y
+	}
+}
+
+// contains returns true if x, y is inside the bounds of the codebox.
+func (c *cells) contains(x, y int) bool {
+	return x >= c.minX && y >= c.minY && x <= c.maxX && y <= c.maxY
+}
+
+// nonEmpty returns every cell that isn't empty, ordered by row and then column. Only core and the cells in
+// sparse are looked at, so it takes no longer for a codebox "p" has grown far.
+func (c *cells) nonEmpty() []SnapshotCell {
+	var out []SnapshotCell
+	for y, row := range c.core {
+		for x, v := range row {
+			if v != (Value{}) {
+				out = append(out, SnapshotCell{x, y, v})
+			}
+		}
+	}
+	for p, v := range c.sparse {
+		if v != (Value{}) {
+			out = append(out, SnapshotCell{p.x, p.y, v})
+		}
+	}
+	if len(c.sparse) > 0 {
+		sort.Slice(out, func(i, j int) bool {
+			return out[i].Y < out[j].Y || out[i].Y == out[j].Y && out[i].X < out[j].X
+		})
+	}
+	return out
+}
+
+// values returns a copy of every cell inside the bounds of the codebox.
+func (c *cells) values() [][]Value {
+	out := make([][]Value, c.maxY-c.minY+1)
+	for i := range out {
+		out[i] = make([]Value, c.maxX-c.minX+1)
+		for ii := range out[i] {
+			out[i][ii] = c.get(c.minX+ii, c.minY+i)
+		}
+	}
+	return out
+}
+
+// rows returns every cell inside the bounds of the codebox as the character it holds, as described by CodeBox.Box.
+func (c *cells) rows() [][]rune {
+	out := make([][]rune, c.maxY-c.minY+1)
+	for i := range out {
+		out[i] = make([]rune, c.maxX-c.minX+1)
+		for ii := range out[i] {
+			out[i][ii] = CellRune(c.get(c.minX+ii, c.minY+i))
+		}
+	}
+	return out
+}
+
+// opcode returns the instruction a cell holding v executes as. The bool is false if v isn't an ASCII character,
+// and so can never be a valid instruction.
+func opcode(v Value) (byte, bool) {
+	if i, ok := v.exactInt(); ok && i >= 0 && i < utf8.RuneSelf {
+		return byte(i), true
+	}
+	return 0, false
+}
+
+// cellRune returns the printable character a cell holding v shows as. Empty cells show as spaces. The bool is
+// false if v isn't a printable character.
+func cellRune(v Value) (rune, bool) {
+	i, ok := v.exactInt()
+	if ok && i == 0 {
+		return ' ', true
+	}
+	if !ok || i < 0 || i > unicode.MaxRune || !unicode.IsPrint(rune(i)) {
+		return 0, false
+	}
+	return rune(i), true
+}
+
+// CellRune returns the character a cell holding v shows as in CodeBox.Box: a space for an empty cell, the
+// character it holds if that's printable, and utf8.RuneError otherwise.
+func CellRune(v Value) rune {
+	if r, ok := cellRune(v); ok {
+		return r
+	}
+	return utf8.RuneError
+}
+
+// cellString returns a readable form of a cell holding v: the character it holds in single quotes, or its value.
+func cellString(v Value) string {
+	if r, ok := cellRune(v); ok {
+		return strconv.QuoteRune(r)
+	}
+	return v.String()
+}
+
+// restore undoes a write to the cell at x, y, putting back its old value and, if the write grew the codebox, its
+// old bounds.
+func (c *cells) restore(x, y int, old Value, bounds *[4]int) {
+	if c.inCore(x, y) {
+		c.core[y][x] = old
+		c.decode(x, y)
+	} else if old == (Value{}) {
+		delete(c.sparse, point{x, y})
+	} else {
+		c.sparse[point{x, y}] = old
+	}
+	if bounds != nil {
+		c.minX, c.minY, c.maxX, c.maxY = bounds[0], bounds[1], bounds[2], bounds[3]
+	}
+}
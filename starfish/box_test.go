@@ -0,0 +1,95 @@
+package starfish
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestGetEmptyCells(t *testing.T) {
+	cB := runscript("aag01-0g11g;\n ", nil, false)
+	if s := cB.Stack(); len(s) != 3 || s[0] != 0 || s[1] != 0 || s[2] != 0 {
+		t.Errorf("expected [0 0 0], got %v", s)
+	}
+}
+
+func TestCellRune(t *testing.T) {
+	for _, c := range []struct {
+		v Value
+		r rune
+	}{
+		{Value{}, ' '}, {Int('a'), 'a'}, {Float('a'), 'a'}, {Rat(big.NewRat('a', 1)), 'a'}, {Int(0x263a), '☺'},
+		{Float(97.5), utf8.RuneError}, {Int(-1), utf8.RuneError}, {Int('\n'), utf8.RuneError},
+		{Int(0x110000), utf8.RuneError},
+	} {
+		if r := CellRune(c.v); r != c.r {
+			t.Errorf("CellRune(%v) = %q, expected %q", c.v, r, c.r)
+		}
+	}
+}
+
+func TestPutOutsideBox(t *testing.T) {
+	cB := runscript(`";"a0p`, nil, false)
+	if w, h := cB.Size(); w != 11 || h != 1 {
+		t.Errorf("expected an 11x1 codebox, got %dx%d", w, h)
+	}
+	if box := cB.Box(); string(box[0]) != `";"a0p    ;` {
+		t.Errorf("unexpected box %q", box[0])
+	}
+
+	cB = runscript(`"x"01-2p";"01-0p`, nil, false)
+	minX, minY, maxX, maxY := cB.Bounds()
+	if minX != -1 || minY != 0 || maxX != 15 || maxY != 2 {
+		t.Errorf("unexpected bounds %d,%d %d,%d", minX, minY, maxX, maxY)
+	}
+	if box := cB.Box(); len(box) != 3 || string(box[0]) != `;"x"01-2p";"01-0p` || string(box[2]) != "x                " {
+		t.Errorf("unexpected box %q", box)
+	}
+	if x, y := cB.Loc(); x != 0 || y != 0 {
+		t.Errorf("expected the fish to wrap to -1,0 and end, got %d,%d", x, y)
+	}
+}
+
+func TestFarAwayMemory(t *testing.T) {
+	var out bytes.Buffer
+	cB := NewCodeBox("55*ff*ff*p ff*ff*g n;", nil, false, WithOutput(&out))
+	for i := 0; i < 50; i++ {
+		if end, err := cB.Swim(); err != nil {
+			t.Fatal(err)
+		} else if end {
+			break
+		}
+	}
+	if minX, minY, maxX, maxY := cB.Bounds(); minX != 0 || minY != 0 || maxX != 225 || maxY != 225 {
+		t.Errorf("unexpected bounds %d,%d %d,%d", minX, minY, maxX, maxY)
+	}
+	if out.String() != "25" {
+		t.Errorf("expected %q, got %q", "25", out.String())
+	}
+	if len(cB.box.core) != 1 {
+		t.Error("the dense core grew")
+	}
+}
+
+func TestNumericCells(t *testing.T) {
+	cB := runscript("aa*3*01p01g01-11p11g52,21p21g;", nil, false)
+	if s := cB.Stack(); len(s) != 3 || s[0] != 300 || s[1] != -1 || s[2] != 2.5 {
+		t.Errorf("expected [300 -1 2.5], got %v", s)
+	}
+	if cells := cB.Cells(); cells[1][0] != Float(300) || cells[1][1] != Float(-1) || cells[1][2] != Float(2.5) {
+		t.Errorf("unexpected cells %v", cells[1])
+	}
+	if box := cB.Box(); string(box[1][:3]) != "Ĭ��" {
+		t.Errorf("unexpected box %q", box[1])
+	}
+
+	cB = NewCodeBox("aa*3*80p", nil, false)
+	var err error
+	for i := 0; err == nil && i < 20; i++ {
+		_, err = cB.Swim()
+	}
+	if rErr, ok := err.(*RuntimeError); !ok || rErr.Kind != UnknownInstruction || rErr.X != 8 || rErr.Cell != Float(300) {
+		t.Fatal(err)
+	}
+}
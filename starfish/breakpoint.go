@@ -0,0 +1,165 @@
+package starfish
+
+import (
+	"fmt"
+	"strconv"
+)
+
+type breakKind byte
+
+const (
+	breakCell breakKind = iota
+	breakInstruction
+	breakCondition
+	watchCell
+	watchRegister
+)
+
+// Breakpoint pauses CodeBox.Run. Breakpoints on cells, instructions and conditions are checked before each
+// tick, so Run pauses with the fish on the instruction that matched. Watchpoints are checked after each tick,
+// so Run pauses just after the change they watch for. Breakpoints are made by the functions below.
+type Breakpoint struct {
+	ID     int // Set by CodeBox.AddBreakpoint
+	kind   breakKind
+	x, y   int
+	dir    Direction
+	anyDir bool
+	op     byte
+	desc   string
+	cond   func(*CodeBox) bool
+}
+
+// AtCell returns a Breakpoint for when the fish is on the cell at x, y.
+func AtCell(x, y int) Breakpoint {
+	return Breakpoint{kind: breakCell, x: x, y: y, anyDir: true}
+}
+
+// AtCellFacing returns a Breakpoint for when the fish is on the cell at x, y, swimming in dir.
+func AtCellFacing(x, y int, dir Direction) Breakpoint {
+	return Breakpoint{kind: breakCell, x: x, y: y, dir: dir}
+}
+
+// OnInstruction returns a Breakpoint for when the fish is about to execute op, such as every "p" or "F". It
+// doesn't match characters pushed in string mode.
+func OnInstruction(op byte) Breakpoint {
+	return Breakpoint{kind: breakInstruction, op: op}
+}
+
+// When returns a Breakpoint for when cond returns true. desc describes the condition.
+func When(desc string, cond func(*CodeBox) bool) Breakpoint {
+	return Breakpoint{kind: breakCondition, desc: desc, cond: cond}
+}
+
+// WhenStackLength returns a Breakpoint for when the current stack holds n values.
+func WhenStackLength(n int) Breakpoint {
+	return When(fmt.Sprintf("stack length is %d", n), func(cB *CodeBox) bool {
+		return cB.p >= 0 && cB.p < len(cB.stacks) && cB.stacks[cB.p].Len() == n
+	})
+}
+
+// WhenTop returns a Breakpoint for when the value on top of the current stack equals v.
+func WhenTop(v Value) Breakpoint {
+	return When(fmt.Sprintf("top of stack is %v", v), func(cB *CodeBox) bool {
+		if cB.p < 0 || cB.p >= len(cB.stacks) || cB.stacks[cB.p].Len() == 0 {
+			return false
+		}
+		c, ok := compare(*cB.stacks[cB.p].top(0), v)
+		return ok && c == 0
+	})
+}
+
+// WatchCell returns a watchpoint for when "p" writes to the cell at x, y.
+func WatchCell(x, y int) Breakpoint {
+	return Breakpoint{kind: watchCell, x: x, y: y}
+}
+
+// WatchRegister returns a watchpoint for when "&" fills a register.
+func WatchRegister() Breakpoint {
+	return Breakpoint{kind: watchRegister}
+}
+
+func (b Breakpoint) String() string {
+	switch b.kind {
+	case breakCell:
+		if b.anyDir {
+			return fmt.Sprintf("cell %d,%d", b.x, b.y)
+		}
+		return fmt.Sprintf("cell %d,%d swimming %v", b.x, b.y, b.dir)
+	case breakInstruction:
+		return "instruction " + strconv.QuoteRune(rune(b.op))
+	case breakCondition:
+		return b.desc
+	case watchCell:
+		return fmt.Sprintf("write to %d,%d", b.x, b.y)
+	case watchRegister:
+		return "register filled"
+	}
+	return "unknown breakpoint"
+}
+
+// IsWatchpoint returns true if b is checked after each tick rather than before.
+func (b Breakpoint) IsWatchpoint() bool {
+	return b.kind == watchCell || b.kind == watchRegister
+}
+
+// AddBreakpoint adds b to the CodeBox and returns its ID.
+func (cB *CodeBox) AddBreakpoint(b Breakpoint) int {
+	cB.nextID++
+	b.ID = cB.nextID
+	cB.breakpoints = append(cB.breakpoints, b)
+	return b.ID
+}
+
+// RemoveBreakpoint removes the breakpoint with the given ID. It returns false if there isn't one.
+func (cB *CodeBox) RemoveBreakpoint(id int) bool {
+	for i, b := range cB.breakpoints {
+		if b.ID == id {
+			cB.breakpoints = append(cB.breakpoints[:i:i], cB.breakpoints[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Breakpoints returns a copy of the CodeBox's breakpoints, in the order they were added.
+func (cB *CodeBox) Breakpoints() []Breakpoint {
+	return append([]Breakpoint(nil), cB.breakpoints...)
+}
+
+// breakBefore returns the first breakpoint that matches before the next tick, if any. A breakpoint that Run
+// paused on doesn't match again until the ><> has moved on.
+func (cB *CodeBox) breakBefore() *Breakpoint {
+	if cB.pausedAt == cB.tick+1 {
+		return nil
+	}
+	for i := range cB.breakpoints {
+		b := &cB.breakpoints[i]
+		var hit bool
+		switch b.kind {
+		case breakCell:
+			hit = cB.fX == b.x && cB.fY == b.y && (b.anyDir || cB.fDir == b.dir)
+		case breakInstruction:
+			r, ok := opcode(cB.box.get(cB.fX, cB.fY))
+			hit = ok && r == b.op && (cB.stringMode == 0 || r == cB.stringMode)
+		case breakCondition:
+			hit = b.cond(cB)
+		}
+		if hit {
+			cB.pausedAt = cB.tick + 1
+			return b
+		}
+	}
+	return nil
+}
+
+// watchAfter returns the first watchpoint that matches the last tick, if any.
+func (cB *CodeBox) watchAfter() *Breakpoint {
+	for i := range cB.breakpoints {
+		b := &cB.breakpoints[i]
+		if (b.kind == watchCell && cB.wroteCell && cB.wrote == point{b.x, b.y}) ||
+			(b.kind == watchRegister && cB.filledRegister) {
+			return b
+		}
+	}
+	return nil
+}
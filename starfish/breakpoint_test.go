@@ -0,0 +1,87 @@
+package starfish
+
+import (
+	"context"
+	"testing"
+)
+
+func TestBreakpoints(t *testing.T) {
+	tests := []struct {
+		script string
+		b      Breakpoint
+		ticks  int64 // Ticks executed before pausing
+		x, y   int   // Where the fish is when Run pauses
+	}{
+		{"12345;", AtCell(3, 0), 3, 3, 0},
+		{"v>2;\n>^", AtCellFacing(1, 0, Up), 3, 1, 0},
+		{"v>2;\n>^", AtCell(1, 1), 2, 1, 1},
+		{`"p"10p"q"00p;`, OnInstruction('p'), 5, 5, 0},
+		{"1234;", WhenStackLength(3), 3, 3, 0},
+		{"1234;", WhenTop(Int(2)), 2, 2, 0},
+		{"1234;", When("always", func(*CodeBox) bool { return true }), 0, 0, 0},
+		{"a55pa56p;", WatchCell(5, 6), 8, 8, 0},
+		{"12&&&;", WatchRegister(), 3, 3, 0},
+	}
+	for _, test := range tests {
+		cB := NewCodeBox(test.script, nil, false)
+		id := cB.AddBreakpoint(test.b)
+		res, err := cB.Run(context.Background(), RunOptions{})
+		if err != nil {
+			t.Fatalf("%q: %v", test.script, err)
+		}
+		if res.Reason != Paused || res.Breakpoint == nil || res.Breakpoint.ID != id {
+			t.Errorf("%q: expected to pause on %v, got %v", test.script, test.b, res.Reason)
+			continue
+		}
+		x, y := cB.Loc()
+		if cB.Tick() != test.ticks || x != test.x || y != test.y {
+			t.Errorf("%q: expected %v to pause at %d,%d after %d ticks, got %d,%d after %d", test.script, test.b,
+				test.x, test.y, test.ticks, x, y, cB.Tick())
+		}
+		if res, err = cB.Run(context.Background(), RunOptions{}); err != nil || res.Ticks == 0 {
+			t.Errorf("%q: expected to move on after continuing, got %v: %v", test.script, res.Reason, err)
+		}
+	}
+}
+
+func TestRemoveBreakpoint(t *testing.T) {
+	cB := NewCodeBox("1&2&3&;", nil, false)
+	first := cB.AddBreakpoint(OnInstruction('&'))
+	cB.AddBreakpoint(WatchRegister())
+	if bs := cB.Breakpoints(); len(bs) != 2 || bs[0].String() != "instruction '&'" || !bs[1].IsWatchpoint() {
+		t.Fatalf("unexpected breakpoints %v", bs)
+	}
+
+	var hits []string
+	for {
+		res, err := cB.Run(context.Background(), RunOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Reason != Paused {
+			break
+		}
+		hits = append(hits, res.Breakpoint.String())
+		if len(hits) == 2 && !cB.RemoveBreakpoint(first) {
+			t.Fatal("couldn't remove the breakpoint")
+		}
+	}
+	want := []string{"instruction '&'", "register filled", "register filled"}
+	if len(hits) != len(want) {
+		t.Fatalf("expected hits %q, got %q", want, hits)
+	}
+	for i := range want {
+		if hits[i] != want[i] {
+			t.Errorf("expected hits %q, got %q", want, hits)
+		}
+	}
+	if cB.RemoveBreakpoint(first) {
+		t.Errorf("removed a breakpoint twice")
+	}
+
+	cB = NewCodeBox("v>2;\n>^", nil, false)
+	cB.AddBreakpoint(AtCellFacing(1, 0, Left))
+	if res, _ := cB.Run(context.Background(), RunOptions{}); res.Reason != Ended {
+		t.Errorf("expected a breakpoint facing the wrong way not to pause, got %v", res.Reason)
+	}
+}
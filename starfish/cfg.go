@@ -0,0 +1,197 @@
+package starfish
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CFG is the control-flow graph of a script: the straight runs of cells the fish swims through, and how it can get
+// from one run to another. It's made by NewCFG.
+type CFG struct {
+	Blocks []Block // Blocks[0] is where the fish starts
+	Edges  []Edge
+}
+
+// Block is a run of cells the fish crosses in one direction, one after another, with no way in but the first cell
+// and no way out but the last.
+type Block struct {
+	ID         int
+	X, Y       int // The first cell
+	Dir        Direction
+	Code       string // The characters of the cells, in the order they're crossed
+	StringMode bool   // Set if the fish is in string mode at the first cell
+	DeepSea    bool   // Set if the fish is in deep sea mode at the first cell
+	End        bool   // Set if the block ends with ";"
+	Fails      bool   // Set if the block ends with an unknown instruction
+	quote      byte   // The quote string mode is on for at the first cell, if any
+}
+
+// Edge is a way the fish can get from the last cell of one block to the first cell of another. Jumps and calls
+// can't be followed statically, so they're edges with Dynamic set and no To block.
+type Edge struct {
+	From, To int    // Block IDs; To is -1 if Dynamic is set
+	Label    string `json:",omitempty"` // How the fish gets there: "zero", "nonzero", "skip", "random", "hook", "wrap"...
+	Dynamic  bool   `json:",omitempty"`
+}
+
+// NewCFG follows every path the fish can take through script from the top-left cell, as Lint does, and returns the
+// graph of the blocks it crosses. Only opts.Encoding is used.
+func NewCFG(script string, opts LintOptions) *CFG {
+	cfg := &CFG{}
+	box := loadScript(script, opts.Encoding)
+	if box.width == 0 {
+		return cfg
+	}
+	g := newFlowGraph(box)
+	preds := make(map[fishState][]fishState)
+	for _, s := range g.order {
+		for _, n := range g.next[s] {
+			preds[n] = append(preds[n], s)
+		}
+	}
+	// straight returns true if the fish swims straight from s to n, without turning, skipping or wrapping
+	straight := func(s, n fishState) bool {
+		return len(g.next[s]) == 1 && n == g.move(s) && n.dir == s.dir && !wrapped(s, n)
+	}
+	starts := make(map[fishState]bool)
+	for _, s := range g.order {
+		if p := preds[s]; s == g.start || len(p) != 1 || !straight(p[0], s) {
+			starts[s] = true
+		}
+	}
+
+	blockOf := make(map[fishState]int)
+	var last []fishState
+	for _, s := range g.order {
+		if !starts[s] {
+			continue
+		}
+		b := Block{ID: len(cfg.Blocks), X: s.x, Y: s.y, Dir: s.dir, StringMode: s.quote != 0, DeepSea: s.deep, quote: s.quote}
+		blockOf[s] = b.ID
+		var code strings.Builder
+		for {
+			r, ok := cellRune(box.get(s.x, s.y))
+			if !ok {
+				r = '�'
+			}
+			code.WriteRune(r)
+			if len(g.next[s]) != 1 || !straight(s, g.next[s][0]) || starts[g.next[s][0]] {
+				break
+			}
+			s = g.next[s][0]
+		}
+		b.Code = code.String()
+		if op, ok := g.op(s); s.quote == 0 && !s.deep {
+			b.End = ok && op == ';'
+			b.Fails = !ok
+		}
+		cfg.Blocks = append(cfg.Blocks, b)
+		last = append(last, s)
+	}
+
+	for id, s := range last {
+		for i, n := range g.next[s] {
+			cfg.Edges = append(cfg.Edges, Edge{From: id, To: blockOf[n], Label: g.edgeLabel(s, n, i)})
+		}
+	}
+	dynamic := make(map[fishState]bool)
+	for _, s := range g.dynamic {
+		dynamic[s] = true
+	}
+	for id, s := range last {
+		if dynamic[s] {
+			op, _ := g.op(s)
+			label := map[byte]string{'.': "jump", 'C': "call", 'R': "return"}[op]
+			cfg.Edges = append(cfg.Edges, Edge{From: id, To: -1, Label: label, Dynamic: true})
+		}
+	}
+	return cfg
+}
+
+// wrapped returns true if the fish wraps around the edge of the codebox getting from s to n.
+func wrapped(s, n fishState) bool {
+	switch n.dir {
+	case Right:
+		return n.x <= s.x
+	case Down:
+		return n.y <= s.y
+	case Left:
+		return n.x >= s.x
+	case Up:
+		return n.y >= s.y
+	}
+	return false
+}
+
+// edgeLabel describes how the fish gets from s to n, its i-th successor.
+func (g *flowGraph) edgeLabel(s, n fishState, i int) string {
+	var labels []string
+	op, ok := g.op(s)
+	if ok && s.quote == 0 {
+		switch {
+		case op == 'x':
+			labels = append(labels, "random")
+		case op == '`':
+			labels = append(labels, "hook")
+		case op == '?' && !s.deep:
+			labels = append(labels, [...]string{"nonzero", "zero"}[i])
+		case op == '!' && !s.deep:
+			labels = append(labels, "skip")
+		}
+	}
+	if wrapped(s, n) {
+		labels = append(labels, "wrap")
+	}
+	return strings.Join(labels, ", ")
+}
+
+// WriteDOT writes cfg to w in the DOT language of Graphviz. Each block is labelled with its first cell, its
+// direction, and its code. Blocks ending the ><> have a double border, and blocks failing on an unknown
+// instruction a red one. Dynamic edges are dashed and lead to a node labelled "?".
+func (cfg *CFG) WriteDOT(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, "digraph cfg {")
+	fmt.Fprintln(bw, `	node [shape=box, fontname="monospace"];`)
+	if len(cfg.Blocks) > 0 {
+		fmt.Fprintln(bw, "	start [shape=point];")
+		fmt.Fprintln(bw, "	start -> b0;")
+	}
+	for _, b := range cfg.Blocks {
+		label := fmt.Sprintf("%d,%d %v", b.X, b.Y, b.Dir)
+		if b.StringMode {
+			label += " (string)"
+		}
+		if b.DeepSea {
+			label += " (deep sea)"
+		}
+		attrs := ""
+		if b.End {
+			attrs += ", peripheries=2"
+		}
+		if b.Fails {
+			attrs += ", color=red"
+		}
+		fmt.Fprintf(bw, "\tb%d [label=\"%s\\l%s\\l\"%s];\n", b.ID, dotEscape(label), dotEscape(b.Code), attrs)
+	}
+	for i, e := range cfg.Edges {
+		if e.Dynamic {
+			fmt.Fprintf(bw, "\tdyn%d [label=\"?\", shape=circle, style=dashed];\n", i)
+			fmt.Fprintf(bw, "\tb%d -> dyn%d [label=\"%s\", style=dashed];\n", e.From, i, dotEscape(e.Label))
+			continue
+		}
+		fmt.Fprintf(bw, "\tb%d -> b%d", e.From, e.To)
+		if e.Label != "" {
+			fmt.Fprintf(bw, " [label=\"%s\"]", dotEscape(e.Label))
+		}
+		fmt.Fprintln(bw, ";")
+	}
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// dotEscape escapes s for a quoted DOT string.
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s)
+}
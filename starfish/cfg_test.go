@@ -0,0 +1,65 @@
+package starfish
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCFG(t *testing.T) {
+	cfg := NewCFG("i?!v;\n   >00.", LintOptions{})
+	blocks := []Block{
+		{ID: 0, X: 0, Y: 0, Dir: Right, Code: "i?"},
+		{ID: 1, X: 2, Y: 0, Dir: Right, Code: "!"},
+		{ID: 2, X: 3, Y: 0, Dir: Right, Code: "v"},
+		{ID: 3, X: 4, Y: 0, Dir: Right, Code: ";", End: true},
+		{ID: 4, X: 3, Y: 1, Dir: Down, Code: ">"},
+		{ID: 5, X: 4, Y: 1, Dir: Right, Code: "00."},
+	}
+	edges := []Edge{
+		{0, 1, "nonzero", false},
+		{0, 2, "zero", false},
+		{1, 3, "skip", false},
+		{2, 4, "", false},
+		{4, 5, "", false},
+		{5, -1, "jump", true},
+	}
+	if !reflect.DeepEqual(cfg.Blocks, blocks) {
+		t.Errorf("blocks: got %+v, expected %+v", cfg.Blocks, blocks)
+	}
+	if !reflect.DeepEqual(cfg.Edges, edges) {
+		t.Errorf("edges: got %+v, expected %+v", cfg.Edges, edges)
+	}
+}
+
+func TestCFGWrap(t *testing.T) {
+	cfg := NewCFG(`"ab`, LintOptions{})
+	if len(cfg.Blocks) != 4 || !cfg.Blocks[1].StringMode || cfg.Blocks[1].Code != "ab" || cfg.Blocks[3].StringMode {
+		t.Errorf("blocks: %+v", cfg.Blocks)
+	}
+	edges := []Edge{{0, 1, "", false}, {1, 2, "wrap", false}, {2, 3, "", false}, {3, 0, "wrap", false}}
+	if !reflect.DeepEqual(cfg.Edges, edges) {
+		t.Errorf("edges: got %+v, expected %+v", cfg.Edges, edges)
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	var b bytes.Buffer
+	if err := NewCFG("i?!v;\n   >00.", LintOptions{}).WriteDOT(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"digraph cfg {",
+		"start -> b0;",
+		`b0 [label="0,0 right\li?\l"];`,
+		`b3 [label="4,0 right\l;\l", peripheries=2];`,
+		`b0 -> b1 [label="nonzero"];`,
+		`b2 -> b4;`,
+		`b5 -> dyn5 [label="jump", style=dashed];`,
+	} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("%q is missing from\n%s", s, b.String())
+		}
+	}
+}
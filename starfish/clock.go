@@ -0,0 +1,120 @@
+package starfish
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for "h", "m", "s" and "S".
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+	// Sleep pauses for d, returning early if ctx is done.
+	Sleep(ctx context.Context, d time.Duration)
+}
+
+// SystemClock is the default Clock. It uses the host's clock and really sleeps.
+type SystemClock struct{}
+
+// Now returns time.Now().
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Sleep pauses for d, returning early if ctx is done.
+func (SystemClock) Sleep(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+	}
+}
+
+// FakeClock is a Clock that only moves when told to. Sleep returns immediately after moving the clock forward,
+// so programs using "S" can be tested without waiting. It's safe to share between CodeBoxes.
+type FakeClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+// NewFakeClock returns a FakeClock set to t.
+func NewFakeClock(t time.Time) *FakeClock {
+	return &FakeClock{t: t}
+}
+
+// Now returns the fake time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+// Sleep moves the fake time forward by d without waiting.
+func (c *FakeClock) Sleep(ctx context.Context, d time.Duration) {
+	c.Advance(d)
+}
+
+// Advance moves the fake time forward by d.
+func (c *FakeClock) Advance(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	c.mu.Lock()
+	c.t = c.t.Add(d)
+	c.mu.Unlock()
+}
+
+// WithClock sets the Clock used by "h", "m", "s" and "S". By default a CodeBox uses SystemClock.
+func WithClock(c Clock) Option {
+	return func(cB *CodeBox) {
+		cB.clock = c
+	}
+}
+
+// WithRandSource sets the source "x" picks directions from. By default each CodeBox gets its own source seeded
+// from the time it was created.
+func WithRandSource(src rand.Source) Option {
+	return func(cB *CodeBox) {
+		cB.rand = rand.New(src)
+	}
+}
+
+// splitMix is the source "x" picks directions from unless WithRandSource is used: SplitMix64, which is much
+// cheaper to seed than the sources in math/rand, so creating a CodeBox stays cheap.
+type splitMix uint64
+
+func (s *splitMix) Seed(seed int64) {
+	*s = splitMix(seed)
+}
+
+func (s *splitMix) Uint64() uint64 {
+	*s += 0x9e3779b97f4a7c15
+	z := uint64(*s)
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return z ^ z>>31
+}
+
+func (s *splitMix) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+// newRand returns a rand.Rand using a splitMix seeded with seed.
+func newRand(seed int64) *rand.Rand {
+	src := splitMix(seed)
+	return rand.New(&src)
+}
+
+// context returns the context passed to Run, or context.Background() outside of Run.
+func (cB *CodeBox) context() context.Context {
+	if cB.ctx == nil {
+		return context.Background()
+	}
+	return cB.ctx
+}
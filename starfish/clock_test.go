@@ -0,0 +1,37 @@
+package starfish
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestFakeClock(t *testing.T) {
+	clock := NewFakeClock(time.Date(2017, 1, 2, 13, 14, 15, 0, time.UTC))
+	cB := runscript("hms;", nil, false, WithClock(clock))
+	if s := cB.Stack(); len(s) != 3 || s[0] != 13 || s[1] != 14 || s[2] != 15 {
+		t.Errorf("expected [13 14 15], got %v", s)
+	}
+
+	start := time.Now()
+	cB = runscript("aa*a*S s;", nil, false, WithClock(clock)) // Sleeps for 100 seconds
+	if time.Since(start) > time.Second {
+		t.Error("S really slept")
+	}
+	if s := cB.Stack(); len(s) != 1 || s[0] != 55 {
+		t.Errorf("expected [55], got %v", s)
+	}
+}
+
+func TestRandSource(t *testing.T) {
+	cB1 := NewCodeBox("x", nil, false, WithRandSource(rand.NewSource(42)))
+	cB2 := NewCodeBox("x", nil, false, WithRandSource(rand.NewSource(42)))
+	for i := 0; i < 100; i++ {
+		cB1.Run(context.Background(), RunOptions{MaxTicks: 1})
+		cB2.Run(context.Background(), RunOptions{MaxTicks: 1})
+		if cB1.fDir != cB2.fDir {
+			t.Fatal("the same seed gave different directions")
+		}
+	}
+}
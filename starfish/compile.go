@@ -0,0 +1,176 @@
+package starfish
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"unicode/utf8"
+)
+
+// CompileOptions controls Compile.
+type CompileOptions struct {
+	Name     string   // The name of the script, for comments and error messages
+	Encoding Encoding // How the script and the input are split into values, as with WithScriptEncoding
+	Numeric  Numeric  // Float64Numeric, Int64Numeric or RationalNumeric; nil means Float64Numeric
+	Compat   bool     // Run in compatibility mode, as for NewCodeBox
+}
+
+// compiledOps maps the instructions the generated code calls a Fish method for to the method.
+var compiledOps = map[byte]string{
+	'+': "Add", '-': "Sub", '*': "Mul", ',': "Div", '%': "Mod", '=': "Equal", ')': "Greater", '(': "Less",
+	':': "Dup", '~': "Drop", '$': "Swap", '@': "Rot", '}': "ShiftRight", '{': "ShiftLeft", 'r': "Reverse",
+	'l': "Length", 'o': "Output", 'n': "OutputNumber", 'g': "Get",
+}
+
+// Compile translates script to the source of a Go program, a main package that runs it with os.Stdin as its
+// input and os.Stdout as its output, as the starfish command does. Every state the fish can reach from the
+// top-left cell, as found by NewCFG, becomes a case of a switch running straight into the next, so the
+// program only checks where the fish is when it turns, branches or wraps around. The program falls back to
+// the interpreter as described for Compiled.Run, so it behaves as the interpreter does even if the script
+// jumps to a target only known at run time or changes its own code.
+func Compile(script string, opts CompileOptions) ([]byte, error) {
+	box := loadScript(script, opts.Encoding)
+	if box.width == 0 {
+		return nil, errors.New("script is empty")
+	}
+	numeric := ""
+	switch opts.Numeric.(type) {
+	case nil, Float64Numeric:
+	case Int64Numeric:
+		numeric = "starfish.Int64Numeric{}"
+	case RationalNumeric:
+		numeric = "starfish.RationalNumeric{}"
+	default:
+		return nil, fmt.Errorf("can't compile for numeric backend %T", opts.Numeric)
+	}
+
+	// Number the states block by block, so each runs straight into the next
+	g := newFlowGraph(box)
+	cfg := NewCFG(script, LintOptions{Encoding: opts.Encoding})
+	var states []fishState
+	index := make(map[fishState]int)
+	for _, b := range cfg.Blocks {
+		s := fishState{x: b.X, y: b.Y, dir: b.Dir, quote: b.quote, deep: b.DeepSea}
+		for i := utf8.RuneCountInString(b.Code); i > 0; i-- {
+			index[s] = len(states)
+			states = append(states, s)
+			if i > 1 {
+				s = g.next[s][0]
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by starfish build from %s. DO NOT EDIT.\n\n", opts.Name)
+	b.WriteString("package main\n\nimport (\n\t\"os\"\n\t\"strconv\"\n\n\t\"github.com/redstarcoder/go-starfish/starfish\"\n)\n\n")
+	b.WriteString("var program = &starfish.Compiled{\n")
+	fmt.Fprintf(&b, "Name: %q,\nScript: %q,\n", opts.Name, script)
+	if opts.Encoding == UTF8 {
+		b.WriteString("Encoding: starfish.UTF8,\n")
+	}
+	if numeric != "" {
+		fmt.Fprintf(&b, "Numeric: %s,\n", numeric)
+	}
+	if opts.Compat {
+		b.WriteString("Compat: true,\n")
+	}
+	b.WriteString("States: []starfish.CompiledState{\n")
+	for _, s := range states {
+		fmt.Fprintf(&b, "{X: %d, Y: %d, Dir: starfish.%s", s.x, s.y, goDirection(s.dir))
+		if s.quote != 0 {
+			fmt.Fprintf(&b, ", Quote: %q", rune(s.quote))
+		}
+		if s.deep {
+			b.WriteString(", DeepSea: true")
+		}
+		b.WriteString("},\n")
+	}
+	b.WriteString("},\nCode: code,\n}\n\n")
+
+	b.WriteString("func code(f *starfish.Fish, st int) bool {\nfor {\nf.Check(st)\nswitch st {\n")
+	for i, s := range states {
+		fmt.Fprintf(&b, "case %d: // %d,%d %v %s\nf.Tick()\n", i, s.x, s.y, s.dir, cellString(box.get(s.x, s.y)))
+		compileState(&b, g, s, i, index)
+	}
+	b.WriteString("default:\npanic(\"no state \" + strconv.Itoa(st))\n}\n}\n}\n\n")
+	b.WriteString("func main() {\nos.Exit(program.Main())\n}\n")
+	return format.Source(b.Bytes())
+}
+
+// compileState writes the Go code for state s, numbered i, to b.
+func compileState(b *bytes.Buffer, g *flowGraph, s fishState, i int, index map[fishState]int) {
+	next := g.next[s]
+	goTo := func(n fishState) {
+		if index[n] == i+1 {
+			b.WriteString("fallthrough\n")
+		} else {
+			fmt.Fprintf(b, "st = %d\n", index[n])
+		}
+	}
+	// byDir writes a switch on the direction "x" or "`" chose
+	byDir := func() {
+		b.WriteString("switch f.Dir() {\n")
+		for _, n := range next {
+			fmt.Fprintf(b, "case starfish.%s:\nst = %d\n", goDirection(n.dir), index[n])
+		}
+		b.WriteString("}\n")
+	}
+
+	op, ok := g.op(s)
+	switch {
+	case s.quote != 0:
+		if ok && op == s.quote {
+			b.WriteString("f.Quote(0)\n")
+		} else {
+			v := g.box.get(s.x, s.y)
+			if v == (Value{}) {
+				v = Int(' ')
+			}
+			fmt.Fprintf(b, "f.Push(%d)\n", v.Int64())
+		}
+	case !ok:
+		if !s.deep {
+			fmt.Fprintf(b, "f.At(%d)\nf.Fail()\n", i)
+			return
+		}
+	case op == 'x' || op == '`':
+		fmt.Fprintf(b, "f.Exe(%q)\n", rune(op))
+		byDir()
+		return
+	case op == 'O' || op == 'u' && !s.deep:
+		fmt.Fprintf(b, "f.Exe(%q)\n", rune(op))
+	case len(next) == 1 && next[0].dir != s.dir:
+		fmt.Fprintf(b, "f.Turn(starfish.%s)\n", goDirection(next[0].dir))
+	case s.deep || op == ' ' || op == 0 || op == '!':
+	case op >= '0' && op <= '9':
+		fmt.Fprintf(b, "f.Push(%d)\n", op-'0')
+	case op >= 'a' && op <= 'f':
+		fmt.Fprintf(b, "f.Push(%d)\n", op-'a'+10)
+	case op == '"' || op == '\'':
+		fmt.Fprintf(b, "f.Quote(%q)\n", rune(op))
+	case op == ';':
+		b.WriteString("return true\n")
+		return
+	case op == '?':
+		fmt.Fprintf(b, "f.At(%d)\nif f.Zero() {\nst = %d\n} else {\nst = %d\n}\n", i, index[next[1]], index[next[0]])
+		return
+	case op == '.' || op == 'C' || op == 'R':
+		fmt.Fprintf(b, "f.At(%d)\nf.%s()\nreturn false\n", i, map[byte]string{'.': "Jump", 'C': "Call", 'R': "Return"}[op])
+		return
+	case op == 'p':
+		fmt.Fprintf(b, "f.At(%d)\nif f.Put() {\nreturn false\n}\n", i)
+	case compiledOps[op] != "":
+		fmt.Fprintf(b, "f.At(%d)\nf.%s()\n", i, compiledOps[op])
+	case op == '>' || op == '<' || op == '^' || op == 'v' || op == '|' || op == '_' || op == '#' || op == '/' || op == '\\':
+		// Leaves the fish swimming the way it was
+	default:
+		fmt.Fprintf(b, "f.At(%d)\nf.Exe(%q)\n", i, rune(op))
+	}
+	goTo(next[0])
+}
+
+// goDirection returns the name of the starfish constant for d.
+func goDirection(d Direction) string {
+	return [...]string{"Right", "Down", "Left", "Up"}[d]
+}
@@ -0,0 +1,204 @@
+package starfish
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompile(t *testing.T) {
+	src, err := Compile("12+n;", CompileOptions{Name: "add.fish", Numeric: Int64Numeric{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{
+		"// Code generated by starfish build from add.fish. DO NOT EDIT.",
+		"Numeric: starfish.Int64Numeric{},",
+		"f.Push(1)\n\t\t\tfallthrough\n",
+		"f.Add()",
+		"return true",
+		"f.Check(st)",
+	} {
+		if !bytes.Contains(src, []byte(s)) {
+			t.Errorf("missing %q in\n%s", s, src)
+		}
+	}
+	if n := bytes.Count(src, []byte("f.Tick()")); n != 5 {
+		t.Errorf("expected a tick counted in each of the 5 states, got %d", n)
+	}
+
+	if _, err = Compile("", CompileOptions{}); err == nil {
+		t.Error("compiled an empty script")
+	}
+	if _, err = Compile(";", CompileOptions{Numeric: struct{ Float64Numeric }{}}); err == nil {
+		t.Error("compiled for an unknown numeric backend")
+	}
+}
+
+// TestCompiledCancel checks a compiled loop that never ends stops when its context is done, with the fish
+// left where it was.
+func TestCompiledCancel(t *testing.T) {
+	c := &Compiled{
+		Script: ">",
+		States: []CompiledState{{X: 0, Y: 0, Dir: Right}},
+		// As Compile writes it for ">"
+		Code: func(f *Fish, st int) bool {
+			for {
+				f.Check(st)
+				switch st {
+				case 0:
+					f.Tick()
+					st = 0
+				}
+			}
+		},
+	}
+	cB := NewCodeBox(c.Script, nil, false)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := c.Run(ctx, cB); err != context.DeadlineExceeded {
+		t.Fatalf("expected the deadline to stop the loop, got %v", err)
+	}
+	if x, y := cB.Loc(); x != 0 || y != 0 || cB.Dir() != Right {
+		t.Errorf("fish left at %d,%d swimming %v", x, y, cB.Dir())
+	}
+	if cB.Tick() < checkEvery {
+		t.Errorf("expected the ticks run to be counted, got %d", cB.Tick())
+	}
+}
+
+// TestCompiledTicks checks compiled code counts ticks as the interpreter does, up to a failing tick.
+func TestCompiledTicks(t *testing.T) {
+	c := &Compiled{
+		Script: "12+~~;",
+		States: []CompiledState{{X: 0, Y: 0, Dir: Right}, {X: 1, Y: 0, Dir: Right}, {X: 2, Y: 0, Dir: Right},
+			{X: 3, Y: 0, Dir: Right}, {X: 4, Y: 0, Dir: Right}, {X: 5, Y: 0, Dir: Right}},
+		// As Compile writes it for "12+~~;"
+		Code: func(f *Fish, st int) bool {
+			for {
+				f.Check(st)
+				switch st {
+				case 0:
+					f.Tick()
+					f.Push(1)
+					fallthrough
+				case 1:
+					f.Tick()
+					f.Push(2)
+					fallthrough
+				case 2:
+					f.Tick()
+					f.At(2)
+					f.Add()
+					fallthrough
+				case 3:
+					f.Tick()
+					f.At(3)
+					f.Drop()
+					fallthrough
+				case 4:
+					f.Tick()
+					f.At(4)
+					f.Drop()
+					fallthrough
+				case 5:
+					f.Tick()
+					return true
+				}
+			}
+		},
+	}
+	cB := NewCodeBox(c.Script, nil, false)
+	if _, ok := c.Run(context.Background(), cB).(*RuntimeError); !ok {
+		t.Fatal("expected the second ~ to fail")
+	}
+	want := NewCodeBox(c.Script, nil, false)
+	want.Run(context.Background(), RunOptions{})
+	if cB.Tick() != want.Tick() || cB.Tick() != 5 {
+		t.Errorf("compiled code ran %d ticks, the interpreter %d", cB.Tick(), want.Tick())
+	}
+}
+
+// TestCompiledOutput builds compiled programs with the go command and checks they write what the interpreter
+// does.
+func TestCompiledOutput(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds Go programs")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("no go command")
+	}
+	tests := []struct {
+		script, input string
+	}{
+		{`"olleh"ooooo;`, ""},
+		{"i:0(?;1+o", "abc\nxyz"},       // Reads until the input ends
+		{"0v\n >1+:aa*=?;", ""},         // Loops until it ends silently
+		{"5\"n\"70p~;", ""},             // Writes "n" over the "~" before reaching it
+		{"12n60.;n;", ""},               // Jumps to code only the interpreter runs
+		{"u1n O2n;", ""},                // Skips "1n" in deep sea mode
+		{"'\"'o\"'\"o;", ""},            // Quotes inside strings
+		{"v\n3\n>:?!;1-:n02.", ""},      // Counts down, jumping back into compiled code
+		{"1~~", ""},                     // Fails with a stack underflow
+		{"aa*:*:*:*:*:*:*:*:*n;", ""},   // Prints a large float64
+		{"\"abc\"r{}$@:2[]&&oooo;", ""}, // Shuffles the stack
+	}
+
+	dir, err := ioutil.TempDir("", "starfish-compile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	lib, err := filepath.Abs(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod := fmt.Sprintf("module fishprog\n\ngo 1.14\n\nrequire %[1]s v0.0.0\n\nreplace %[1]s => %[2]s\n",
+		"github.com/redstarcoder/go-starfish/starfish", lib)
+	if err = ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(mod), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i, test := range tests {
+		src, err := Compile(test.script, CompileOptions{Name: "test.fish"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		os.Mkdir(filepath.Join(dir, fmt.Sprint("p", i)), 0755)
+		if err = ioutil.WriteFile(filepath.Join(dir, fmt.Sprint("p", i), "main.go"), src, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cmd := exec.Command("go", "build", "-o", filepath.Join(dir, "bin")+string(filepath.Separator), "./...")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+
+	for i, test := range tests {
+		var want bytes.Buffer
+		cB := NewCodeBox(test.script, nil, false, WithInput(strings.NewReader(test.input)), WithOutput(&want),
+			WithInputMode(Blocking))
+		_, runErr := cB.Run(context.Background(), RunOptions{})
+
+		var got, stderr bytes.Buffer
+		cmd := exec.Command(filepath.Join(dir, "bin", fmt.Sprint("p", i)))
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = strings.NewReader(test.input), &got, &stderr
+		err := cmd.Run()
+		if got.String() != want.String() {
+			t.Errorf("%q: got %q, expected %q", test.script, got.String(), want.String())
+		}
+		if (err != nil) != (runErr != nil) {
+			t.Errorf("%q: got error %v, expected %v", test.script, err, runErr)
+		}
+		if runErr != nil && !strings.Contains(stderr.String(), runErr.Error()) {
+			t.Errorf("%q: got %q, expected %q", test.script, stderr.String(), runErr)
+		}
+	}
+}
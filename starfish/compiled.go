@@ -0,0 +1,355 @@
+package starfish
+
+import (
+	"context"
+	"fmt"
+	"os"
+)
+
+// Compiled is a script compiled to Go by Compile. The generated program fills it in and calls Main; it isn't
+// meant to be written by hand.
+type Compiled struct {
+	Name     string // The name of the script, for error messages
+	Script   string
+	Encoding Encoding // The encoding of the script and the input
+	Numeric  Numeric  // nil means Float64Numeric
+	Compat   bool     // Whether to run in compatibility mode, as for NewCodeBox
+	// States are the states the fish can reach. Code runs the fish from States[state] until it executes ";",
+	// which makes it return true, or it can't carry on statically, which makes it return false.
+	States []CompiledState
+	Code   func(f *Fish, state int) bool
+}
+
+// CompiledState is where the fish is, where it's swimming, and its modes, as it reaches a cell.
+type CompiledState struct {
+	X, Y    int
+	Dir     Direction
+	Quote   byte // The quote string mode is on for, if any
+	DeepSea bool
+}
+
+// Main runs the compiled script as the starfish command would, with os.Stdin as its input and os.Stdout as
+// its output, and returns the exit code.
+func (c *Compiled) Main() int {
+	opts := []Option{WithScriptEncoding(c.Encoding), WithInputEncoding(c.Encoding)}
+	if c.Numeric != nil {
+		opts = append(opts, WithNumeric(c.Numeric))
+	}
+	if fi, err := os.Stdin.Stat(); err != nil || fi.Mode()&os.ModeCharDevice == 0 {
+		opts = append(opts, WithInputMode(Blocking))
+	}
+	cB := NewCodeBox(c.Script, nil, c.Compat, opts...)
+	err := c.Run(context.Background(), cB)
+	if err == nil {
+		return 0
+	}
+	if rErr, ok := err.(*RuntimeError); ok {
+		fmt.Fprintf(os.Stderr, "%s:%d:%d: %v\n", c.Name, rErr.Y+1, rErr.X+1, rErr)
+		fmt.Fprintln(os.Stderr, "Stack:", rErr.Stack)
+		fmt.Fprintln(os.Stderr, "something smells fishy...")
+	} else {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	return 1
+}
+
+// Run runs cB, which must have been created from c.Script, with the compiled code until the ><> executes ";"
+// or fails. After a jump or call to a state the compiled code doesn't cover, or once "p" changes a cell the
+// compiled code depends on or grows the codebox, the rest of the script is interpreted with CodeBox.Run.
+// Errors are returned as by CodeBox.Run, and ctx can cut the compiled code short as it can CodeBox.Run.
+func (c *Compiled) Run(ctx context.Context, cB *CodeBox) error {
+	f := &Fish{cB: cB, states: c.States, code: c.Code, bounds: [4]int{cB.box.minX, cB.box.minY, cB.box.maxX, cB.box.maxY}}
+	index := make(map[fishState]int, len(c.States))
+	f.cells = make(map[point]Value, len(c.States))
+	for i, s := range c.States {
+		index[fishState{s.X, s.Y, s.Dir, s.Quote, s.DeepSea}] = i
+		f.cells[point{s.X, s.Y}] = cB.box.get(s.X, s.Y)
+	}
+
+	cB.ctx = ctx
+	for !f.stale {
+		st, ok := index[fishState{cB.fX, cB.fY, cB.fDir, cB.stringMode, cB.deepSea}]
+		if !ok {
+			break
+		}
+		end, err := f.run(st)
+		if err != nil {
+			cB.ctx = nil
+			return err
+		}
+		if end {
+			cB.ctx = nil
+			return cB.Flush()
+		}
+		cB.Move()
+	}
+	cB.ctx = nil
+	_, err := cB.Run(ctx, RunOptions{})
+	return err
+}
+
+// Fish runs compiled code on a CodeBox. Its methods execute single instructions for the code generated by
+// Compile, and aren't meant to be called by hand. The fish's position is only kept up to date where the
+// generated code calls At, before instructions that can fail or need it.
+type Fish struct {
+	cB     *CodeBox
+	states []CompiledState
+	code   func(f *Fish, state int) bool
+	at     int             // The state the fish is in, as last set by At
+	cells  map[point]Value // The cells the compiled code depends on
+	bounds [4]int          // The bounds of the codebox the compiled code depends on
+	stale  bool            // Set once "p" has changed one of cells or the bounds
+	checks int             // Calls to Check since it last looked at the context
+}
+
+// run runs the compiled code from state st, turning faults into RuntimeErrors.
+func (f *Fish) run(st int) (end bool, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			s := f.states[f.at]
+			switch r := rec.(type) {
+			case interrupted:
+				f.cB.fX, f.cB.fY, f.cB.fDir = s.X, s.Y, s.Dir
+				f.cB.tick--
+				err = r.err
+			case fault:
+				err = f.cB.fail(r, s.X, s.Y, s.Dir)
+			default:
+				panic(rec)
+			}
+		}
+	}()
+	return f.code(f, st), nil
+}
+
+// sync moves the fish to the state last set by At.
+func (f *Fish) sync() {
+	s := f.states[f.at]
+	f.cB.fX, f.cB.fY, f.cB.fDir = s.X, s.Y, s.Dir
+}
+
+// Check panics with the context's error, leaving the fish in state st, once the context given to Compiled.Run
+// is done. The generated code calls it every time it picks the next state other than by falling through, which
+// every loop does, but it only looks at the context every checkEvery calls.
+func (f *Fish) Check(st int) {
+	if f.checks++; f.checks < checkEvery {
+		return
+	}
+	f.checks = 0
+	if ctx := f.cB.ctx; ctx != nil && ctx.Err() != nil {
+		f.at = st
+		f.cB.tick++ // Counted as a tick cut short, which run takes back
+		panic(interrupted{ctx.Err()})
+	}
+}
+
+// Tick counts a tick, as the interpreter does before executing a cell. The generated code calls it as the fish
+// reaches each state.
+func (f *Fish) Tick() {
+	f.cB.tick++
+}
+
+// At records that the fish is in state i.
+func (f *Fish) At(i int) {
+	f.at = i
+}
+
+// Turn makes the fish swim in d, as ">", "v", "<", "^" and the mirrors do.
+func (f *Fish) Turn(d Direction) {
+	f.cB.fDir = d
+	switch d {
+	case Right:
+		f.cB.wasLeft = false
+	case Left:
+		f.cB.wasLeft = true
+	}
+}
+
+// Dir returns the direction the fish is swimming, as chosen by "x" or "`".
+func (f *Fish) Dir() Direction {
+	return f.cB.fDir
+}
+
+// Quote turns string mode on for quote q, or off if q is 0.
+func (f *Fish) Quote(q byte) {
+	f.cB.stringMode = q
+}
+
+// Push pushes n, as a literal or a character in a string does.
+func (f *Fish) Push(n int64) {
+	f.cB.Push(f.cB.num.FromInt(n))
+}
+
+// Exe executes op with CodeBox.Exe, for instructions the generated code doesn't handle itself.
+func (f *Fish) Exe(op byte) {
+	f.cB.Exe(op)
+}
+
+// Fail fails with an unknown instruction.
+func (f *Fish) Fail() {
+	fishy(UnknownInstruction, nil)
+}
+
+// Add implements "+".
+func (f *Fish) Add() {
+	x := f.cB.Pop()
+	y := f.cB.Pop()
+	f.cB.Push(f.cB.num.Add(y, x))
+}
+
+// Sub implements "-".
+func (f *Fish) Sub() {
+	x := f.cB.Pop()
+	y := f.cB.Pop()
+	f.cB.Push(f.cB.num.Sub(y, x))
+}
+
+// Mul implements "*".
+func (f *Fish) Mul() {
+	x := f.cB.Pop()
+	y := f.cB.Pop()
+	f.cB.Push(f.cB.num.Mul(y, x))
+}
+
+// Div implements ",".
+func (f *Fish) Div() {
+	x := f.cB.Pop()
+	y := f.cB.Pop()
+	v, err := f.cB.num.Div(y, x)
+	if err != nil {
+		fishy(DivisionByZero, nil)
+	}
+	f.cB.Push(v)
+}
+
+// Mod implements "%".
+func (f *Fish) Mod() {
+	x := f.cB.Pop()
+	y := f.cB.Pop()
+	v, err := f.cB.num.Mod(y, x)
+	if err != nil {
+		fishy(DivisionByZero, nil)
+	}
+	f.cB.Push(v)
+}
+
+// Equal implements "=".
+func (f *Fish) Equal() {
+	c, ok := compare(f.cB.Pop(), f.cB.Pop())
+	f.cB.pushBool(ok && c == 0)
+}
+
+// Greater implements ")".
+func (f *Fish) Greater() {
+	x := f.cB.Pop()
+	y := f.cB.Pop()
+	c, ok := compare(y, x)
+	f.cB.pushBool(ok && c > 0)
+}
+
+// Less implements "(".
+func (f *Fish) Less() {
+	x := f.cB.Pop()
+	y := f.cB.Pop()
+	c, ok := compare(y, x)
+	f.cB.pushBool(ok && c < 0)
+}
+
+// Zero implements "?", returning true if the fish skips the next instruction.
+func (f *Fish) Zero() bool {
+	return f.cB.Pop().IsZero()
+}
+
+// Dup implements ":".
+func (f *Fish) Dup() {
+	f.cB.ExtendStack()
+}
+
+// Drop implements "~".
+func (f *Fish) Drop() {
+	f.cB.Pop()
+}
+
+// Swap implements "$".
+func (f *Fish) Swap() {
+	f.cB.StackSwapTwo()
+}
+
+// Rot implements "@".
+func (f *Fish) Rot() {
+	f.cB.StackSwapThree()
+}
+
+// ShiftRight implements "}".
+func (f *Fish) ShiftRight() {
+	f.cB.StackShiftRight()
+}
+
+// ShiftLeft implements "{".
+func (f *Fish) ShiftLeft() {
+	f.cB.StackShiftLeft()
+}
+
+// Reverse implements "r".
+func (f *Fish) Reverse() {
+	f.cB.ReverseStack()
+}
+
+// Length implements "l".
+func (f *Fish) Length() {
+	f.cB.Push(f.cB.num.FromInt(int64(f.cB.stack().Len())))
+}
+
+// Output implements "o".
+func (f *Fish) Output() {
+	f.cB.writeValue(f.cB.Pop())
+}
+
+// OutputNumber implements "n".
+func (f *Fish) OutputNumber() {
+	n := f.cB.Pop().String()
+	f.cB.writer().WriteString(n)
+	f.cB.traceString(n)
+}
+
+// Get implements "g".
+func (f *Fish) Get() {
+	y := f.cB.coord(f.cB.Pop())
+	x := f.cB.coord(f.cB.Pop())
+	f.cB.Push(f.cB.num.Convert(f.cB.box.get(x, y)))
+}
+
+// Put implements "p". It returns true if the write changes a cell the compiled code depends on or grows the
+// codebox, so the fish has to carry on in the interpreter.
+func (f *Fish) Put() bool {
+	y := f.cB.coord(f.cB.Pop())
+	x := f.cB.coord(f.cB.Pop())
+	v := f.cB.Pop()
+	f.cB.setCell(x, y, v)
+	box := &f.cB.box
+	if old, ok := f.cells[point{x, y}]; (ok && old != v) || [4]int{box.minX, box.minY, box.maxX, box.maxY} != f.bounds {
+		f.stale = true
+		f.sync()
+	}
+	return f.stale
+}
+
+// Jump implements ".".
+func (f *Fish) Jump() {
+	f.sync()
+	y := f.cB.coord(f.cB.Pop())
+	x := f.cB.coord(f.cB.Pop())
+	f.cB.jump(x, y)
+}
+
+// Call implements "C".
+func (f *Fish) Call() {
+	f.sync()
+	f.cB.Call()
+}
+
+// Return implements "R".
+func (f *Fish) Return() {
+	f.sync()
+	f.cB.Ret()
+}
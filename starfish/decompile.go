@@ -0,0 +1,538 @@
+package starfish
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// decompileHeader starts the pseudocode Decompile writes, explaining it.
+const decompileHeader = `// s0, s1... are stack slots counted from the bottom, used where the length of the stack is known. t1, t2...
+// are temporaries. push and pop work on the current stack where its length isn't known. A string stands for its
+// characters, pushed first to last. Each block is marked with the cell it starts at and the direction the fish
+// swims through it.
+`
+
+// Decompile turns script into structured pseudocode, following every path the fish can take from the top-left
+// cell as NewCFG does. Cycles in the fish's path become loops, "?" becomes if/else, strings become string
+// constants, and where AnalyzeStack knows the length of the stack, values are kept in named stack slots instead
+// of being pushed and popped. Paths that can't be structured are joined with goto. opts.Stack is the length of
+// the initial stack. The pseudocode starts with a comment explaining it.
+func Decompile(script string, opts LintOptions) string {
+	d := &decompiler{cfg: NewCFG(script, opts), box: loadScript(script, opts.Encoding), depth: make(map[fishState]StackDepth)}
+	if len(d.cfg.Blocks) == 0 {
+		return "// empty script\n"
+	}
+	for _, sd := range AnalyzeStack(script, opts).Depths {
+		d.depth[fishState{x: sd.X, y: sd.Y, dir: sd.Dir}] = sd
+	}
+	d.out = make([][]Edge, len(d.cfg.Blocks))
+	d.in = make([]int, len(d.cfg.Blocks))
+	for _, e := range d.cfg.Edges {
+		d.out[e.From] = append(d.out[e.From], e)
+		if !e.Dynamic {
+			d.in[e.To]++
+		}
+	}
+	d.findLoops()
+	d.emitted = make([]bool, len(d.cfg.Blocks))
+	d.referenced = make(map[int]bool)
+	d.block(0, 0, nil)
+
+	var b strings.Builder
+	b.WriteString(decompileHeader)
+	for _, l := range d.lines {
+		if l.label >= 0 {
+			if d.referenced[l.label] {
+				fmt.Fprintf(&b, "%sB%d:\n", strings.Repeat("\t", l.indent), l.label)
+			}
+			continue
+		}
+		fmt.Fprintf(&b, "%s%s\n", strings.Repeat("\t", l.indent), l.text)
+	}
+	return b.String()
+}
+
+// decompiler holds the state of Decompile.
+type decompiler struct {
+	cfg        *CFG
+	box        cells
+	depth      map[fishState]StackDepth // Keyed by cell and direction only
+	out        [][]Edge                 // The edges leaving each block
+	in         []int                    // How many edges lead to each block
+	header     []bool                   // Set for blocks that start a loop
+	emitted    []bool
+	referenced map[int]bool // Blocks some goto leads to
+	lines      []line
+	temps      int
+
+	// The block being decompiled
+	indent int
+	stack  []expr // Values pushed but not yet written to the stack
+	base   int    // Length of the stack under stack, or -1 if it isn't known
+	text   []rune // Characters written by "o" but not yet printed
+	carry  bool   // Set if the next block carries on from the last, keeping stack, base and text
+}
+
+// line is a line of pseudocode. Lines with a label of 0 or more mark where block label starts.
+type line struct {
+	indent int
+	text   string
+	label  int
+}
+
+// expr is a value in pseudocode.
+type expr struct {
+	text    string
+	atomic  bool  // Set if text doesn't need parentheses
+	literal bool  // Set if the value is n
+	char    bool  // Set if the value came from a character, and is shown as one
+	cmp     bool  // Set if the value is a comparison
+	n       int64 // The value, if literal
+}
+
+func number(n int64) expr {
+	return expr{text: strconv.FormatInt(n, 10), atomic: true, literal: true, n: n}
+}
+
+func character(r rune) expr {
+	return expr{text: strconv.QuoteRune(r), atomic: true, literal: true, char: true, n: int64(r)}
+}
+
+func name(s string) expr {
+	return expr{text: s, atomic: true}
+}
+
+// paren returns e's text, in parentheses unless it's atomic.
+func (e expr) paren() string {
+	if e.atomic {
+		return e.text
+	}
+	return "(" + e.text + ")"
+}
+
+// findLoops marks the blocks that back edges lead to, searching depth first from the first block.
+func (d *decompiler) findLoops() {
+	d.header = make([]bool, len(d.cfg.Blocks))
+	state := make([]byte, len(d.cfg.Blocks)) // 0 unvisited, 1 on the path, 2 done
+	var visit func(int)
+	visit = func(b int) {
+		state[b] = 1
+		for _, e := range d.out[b] {
+			if e.Dynamic {
+				continue
+			}
+			switch state[e.To] {
+			case 0:
+				visit(e.To)
+			case 1:
+				d.header[e.To] = true
+			}
+		}
+		state[b] = 2
+	}
+	visit(0)
+}
+
+// emit adds a line of pseudocode, after any text waiting to be printed.
+func (d *decompiler) emit(format string, args ...interface{}) {
+	d.flushText()
+	d.lines = append(d.lines, line{d.indent, fmt.Sprintf(format, args...), -1})
+}
+
+// flushText prints the characters "o" has written since the last line.
+func (d *decompiler) flushText() {
+	if len(d.text) == 0 {
+		return
+	}
+	text := d.text
+	d.text = nil
+	if len(text) == 1 {
+		d.emit("putc(%s)", strconv.QuoteRune(text[0]))
+	} else {
+		d.emit("puts(%s)", strconv.Quote(string(text)))
+	}
+}
+
+// temp returns a new temporary holding e.
+func (d *decompiler) temp(e expr) expr {
+	d.temps++
+	t := name("t" + strconv.Itoa(d.temps))
+	d.emit("%s = %s", t.text, e.text)
+	return t
+}
+
+func (d *decompiler) push(e expr) {
+	d.stack = append(d.stack, e)
+}
+
+// pop returns the value on top of the stack: one pushed in the block, a stack slot, or a temporary popped from
+// the stack.
+func (d *decompiler) pop() expr {
+	if n := len(d.stack); n > 0 {
+		e := d.stack[n-1]
+		d.stack = d.stack[:n-1]
+		return e
+	}
+	if d.base > 0 {
+		d.base--
+		return name("s" + strconv.Itoa(d.base))
+	}
+	d.base = -1
+	return d.temp(name("pop()"))
+}
+
+// flush writes the values pushed in the block to the stack, and reports whether it wrote anything.
+func (d *decompiler) flush() bool {
+	if len(d.stack) == 0 {
+		return false
+	}
+	stack := d.stack
+	d.stack = nil
+	if d.base < 0 {
+		d.emit("push(%s)", joinValues(stack))
+		return true
+	}
+	var names []string
+	var values []expr
+	for i, e := range stack {
+		if n := "s" + strconv.Itoa(d.base+i); e.text != n {
+			names = append(names, n)
+			values = append(values, e)
+		}
+	}
+	d.base += len(stack)
+	if len(names) == 0 {
+		return false
+	}
+	d.emit("%s = %s", strings.Join(names, ", "), joinValues(values))
+	return true
+}
+
+// joinValues returns values separated by commas, with runs of characters joined into strings.
+func joinValues(values []expr) string {
+	var parts []string
+	for i := 0; i < len(values); {
+		j := i
+		for j < len(values) && values[j].char {
+			j++
+		}
+		if j-i > 1 {
+			var s []rune
+			for _, e := range values[i:j] {
+				s = append(s, rune(e.n))
+			}
+			parts = append(parts, strconv.Quote(string(s)))
+			i = j
+			continue
+		}
+		parts = append(parts, values[i].text)
+		i++
+	}
+	return strings.Join(parts, ", ")
+}
+
+// unknown flushes the values pushed in the block and forgets the length of the stack, before an instruction
+// that changes it in ways that can't be followed.
+func (d *decompiler) unknown(format string, args ...interface{}) {
+	d.flush()
+	d.emit(format, args...)
+	d.base = -1
+}
+
+// block writes the pseudocode of block b and everything that follows it, unless it's been written already.
+// loops holds the loops b is inside.
+func (d *decompiler) block(b, indent int, loops []int) {
+	d.emitted[b] = true
+	d.indent = indent
+	if d.header[b] {
+		d.emit("loop L%d {", b)
+		loops = append(loops, b)
+		indent++
+		defer func() {
+			d.indent = indent - 1
+			d.emit("}")
+		}()
+		d.indent = indent
+	}
+	blk := d.cfg.Blocks[b]
+	d.lines = append(d.lines, line{indent, "", b})
+	if !d.carry {
+		d.emit("// %d,%d %v", blk.X, blk.Y, blk.Dir)
+		d.stack, d.base, d.text = nil, -1, nil
+		if sd, ok := d.depth[fishState{x: blk.X, y: blk.Y, dir: blk.Dir}]; ok && !sd.Unbounded && sd.Min == sd.Max {
+			d.base = sd.Min
+		}
+	}
+	d.carry = false
+	s := fishState{x: blk.X, y: blk.Y, dir: blk.Dir, quote: blk.quote, deep: blk.DeepSea}
+	n := utf8.RuneCountInString(blk.Code)
+	g := &flowGraph{box: d.box}
+	var op byte
+	for i := 0; i < n; i++ {
+		op = d.cell(&s)
+		if i < n-1 {
+			s = g.move(s)
+		}
+	}
+
+	edges := d.out[b]
+	switch {
+	case blk.End:
+		d.emit("end")
+	case blk.Fails:
+		d.emit("fail(%q)", "unknown instruction "+cellString(d.box.get(s.x, s.y)))
+	case len(edges) == 1 && edges[0].Dynamic:
+		d.dynamic(op)
+	case len(edges) == 2 && (edges[0].Label == "nonzero" || strings.HasPrefix(edges[0].Label, "nonzero,")):
+		d.branch(edges, indent, loops)
+	case len(edges) > 1:
+		kind := "hook"
+		if op == 'x' {
+			kind = "random direction"
+		}
+		d.flush()
+		d.emit("switch %s {", kind)
+		for _, e := range edges {
+			d.indent = indent
+			d.emit("case %v:", d.cfg.Blocks[e.To].Dir)
+			d.jump(e.To, indent+1, loops)
+		}
+		d.indent = indent
+		d.emit("}")
+	case len(edges) == 1:
+		if to := edges[0].To; !d.emitted[to] && !d.header[to] && d.in[to] == 1 {
+			// The fish can only get to the next block from this one, so it carries on with what it knows
+			d.carry = true
+			d.block(to, indent, loops)
+			break
+		}
+		d.flush()
+		d.jump(edges[0].To, indent, loops)
+	}
+}
+
+// branch writes "?" as if/else.
+func (d *decompiler) branch(edges []Edge, indent int, loops []int) {
+	c := d.pop()
+	if len(d.stack) > 0 && !c.literal {
+		c = d.temp(c) // flush may write to the slots c is made of
+	}
+	d.flush()
+	cond := c.text
+	if !c.cmp {
+		cond = c.paren() + " != 0"
+	}
+	d.emit("if %s {", cond)
+	d.jump(edges[0].To, indent+1, loops)
+	d.indent = indent
+	d.emit("} else {")
+	d.jump(edges[1].To, indent+1, loops)
+	d.indent = indent
+	d.emit("}")
+}
+
+// dynamic writes a jump, call or return, whose target isn't known statically.
+func (d *decompiler) dynamic(op byte) {
+	if op == 'R' {
+		d.flush()
+		d.emit("return")
+		return
+	}
+	y, x := d.pop(), d.pop()
+	if len(d.stack) > 0 {
+		if !x.literal {
+			x = d.temp(x)
+		}
+		if !y.literal {
+			y = d.temp(y)
+		}
+	}
+	d.flush()
+	if op == 'C' {
+		d.emit("call(%s, %s)", x.text, y.text)
+	} else {
+		d.emit("jump(%s, %s)", x.text, y.text)
+	}
+}
+
+// jump writes the way to block b: continuing a loop, a goto, or b itself.
+func (d *decompiler) jump(b, indent int, loops []int) {
+	d.indent = indent
+	for _, l := range loops {
+		if l == b {
+			d.emit("continue L%d", b)
+			return
+		}
+	}
+	if d.emitted[b] {
+		d.referenced[b] = true
+		d.emit("goto B%d", b)
+		return
+	}
+	d.block(b, indent, loops)
+}
+
+// cell writes the pseudocode of the cell s is on, updating s's modes, and returns its instruction.
+func (d *decompiler) cell(s *fishState) byte {
+	v := d.box.get(s.x, s.y)
+	op, ok := opcode(v)
+	if s.quote != 0 {
+		if ok && op == s.quote {
+			s.quote = 0
+			return op
+		}
+		if v == (Value{}) {
+			v = Int(' ')
+		}
+		if r, ok := cellRune(v); ok {
+			d.push(character(r))
+		} else {
+			d.push(number(v.Int64()))
+		}
+		return op
+	}
+	if !ok {
+		return 0
+	}
+	if s.deep {
+		if op == 'O' {
+			s.deep = false
+			d.emit("// deep sea mode ends")
+		}
+		return op
+	}
+
+	switch {
+	case op >= '0' && op <= '9':
+		d.push(number(int64(op - '0')))
+	case op >= 'a' && op <= 'f':
+		d.push(number(int64(op-'a') + 10))
+	}
+	switch op {
+	case '"', '\'':
+		s.quote = op
+	case 'u':
+		s.deep = true
+		d.flush()
+		d.emit("// deep sea mode: only movement and \"O\" take effect")
+	case '+', '-', '*':
+		d.arith(op)
+	case ',':
+		d.binary("/", false)
+	case '%':
+		d.binary("%", false)
+	case '=':
+		d.binary("==", true)
+	case ')':
+		d.binary(">", true)
+	case '(':
+		d.binary("<", true)
+	case ':':
+		e := d.pop()
+		if !e.atomic {
+			e = d.temp(e)
+		}
+		d.push(e)
+		d.push(e)
+	case '~':
+		d.pop()
+	case '$':
+		x, y := d.pop(), d.pop()
+		d.push(x)
+		d.push(y)
+	case '@':
+		x, y, z := d.pop(), d.pop(), d.pop()
+		d.push(x)
+		d.push(z)
+		d.push(y)
+	case 'r', '{', '}':
+		// Shifting an empty stack underflows, which is left to the function
+		if d.base < 0 || op != 'r' && d.base+len(d.stack) == 0 {
+			d.unknown(map[byte]string{'r': "reverse()", '{': "shiftleft()", '}': "shiftright()"}[op])
+			break
+		}
+		all := make([]expr, d.base+len(d.stack))
+		for i := len(all) - 1; i >= 0; i-- {
+			all[i] = d.pop()
+		}
+		switch op {
+		case 'r':
+			for i := len(all) - 1; i >= 0; i-- {
+				d.push(all[i])
+			}
+		case '{':
+			d.stack = append(all[1:], all[0])
+		case '}':
+			d.stack = append([]expr{all[len(all)-1]}, all[:len(all)-1]...)
+		}
+	case 'l':
+		if d.base >= 0 {
+			d.push(number(int64(d.base + len(d.stack))))
+		} else {
+			d.flush()
+			d.push(d.temp(name("len()")))
+		}
+	case 'o':
+		e := d.pop()
+		if e.literal && (unicode.IsPrint(rune(e.n)) || e.n == '\n') {
+			d.text = append(d.text, rune(e.n))
+		} else {
+			d.emit("putc(%s)", e.text)
+		}
+	case 'n':
+		d.emit("putn(%s)", d.pop().text)
+	case 'g':
+		y, x := d.pop(), d.pop()
+		d.push(d.temp(name(fmt.Sprintf("cell(%s, %s)", x.text, y.text))))
+	case 'p':
+		y, x, v := d.pop(), d.pop(), d.pop()
+		d.emit("setcell(%s, %s, %s)", x.text, y.text, v.text)
+	case 'i':
+		d.push(d.temp(name("input()")))
+	case 'h', 'm', 's':
+		d.push(d.temp(name(map[byte]string{'h': "hour()", 'm': "minute()", 's': "second()"}[op])))
+	case 'S':
+		d.emit("sleep(%s)", d.pop().text)
+	case '&':
+		d.unknown("register()")
+	case '[':
+		n := d.pop()
+		d.unknown("newstack(%s)", n.text)
+	case ']':
+		d.unknown("closestack()")
+	case 'I':
+		d.unknown("nextstack()")
+	case 'D':
+		d.unknown("prevstack()")
+	case 'F':
+		n := d.pop()
+		d.unknown("file(%s)", n.text)
+	}
+	return op
+}
+
+// arith writes "+", "-" or "*", working out the result if both values are numbers.
+func (d *decompiler) arith(op byte) {
+	x, y := d.pop(), d.pop()
+	if x.literal && y.literal && !x.char && !y.char {
+		switch op {
+		case '+':
+			d.push(number(y.n + x.n))
+		case '-':
+			d.push(number(y.n - x.n))
+		case '*':
+			d.push(number(y.n * x.n))
+		}
+		return
+	}
+	d.push(expr{text: y.paren() + " " + string(op) + " " + x.paren()})
+}
+
+// binary writes an instruction taking two values as "y op x".
+func (d *decompiler) binary(op string, cmp bool) {
+	x, y := d.pop(), d.pop()
+	d.push(expr{text: y.paren() + " " + op + " " + x.paren(), cmp: cmp})
+}
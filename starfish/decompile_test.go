@@ -0,0 +1,105 @@
+package starfish
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDecompile(t *testing.T) {
+	tests := []struct {
+		script string
+		expect string
+	}{
+		{"", "// empty script\n"},
+		{`"hello"ooooo;`, `// 0,0 right
+puts("olleh")
+end
+`},
+		{"v\n>12$-n;", `// 0,0 right
+putn(1)
+end
+`},
+		{`"abc"r:n;`, `// 0,0 right
+putn('a')
+end
+`},
+		{"{;", `// 0,0 right
+shiftleft()
+end
+`},
+		{"};", `// 0,0 right
+shiftright()
+end
+`},
+		{"r{;", `// 0,0 right
+shiftleft()
+end
+`},
+		{"i:0(?;o", `loop L0 {
+	// 0,0 right
+	t1 = input()
+	t2 = t1 < 0
+	s0 = t1
+	if t2 != 0 {
+		// 5,0 right
+		end
+	} else {
+		// 6,0 right
+		putc(s0)
+		continue L0
+	}
+}
+`},
+		{"x;\n;", `// 0,0 right
+switch random direction {
+case right:
+	// 1,0 right
+	end
+case down:
+	// 0,1 down
+	end
+case left:
+	// 1,0 left
+	end
+case up:
+	// 0,1 up
+	end
+}
+`},
+		{"1[:n];", `// 0,0 right
+newstack(1)
+t1 = pop()
+putn(t1)
+push(t1)
+closestack()
+end
+`},
+		{"ab.", `// 0,0 right
+jump(10, 11)
+`},
+		{"1k", `// 0,0 right
+fail("unknown instruction 'k'")
+`},
+	}
+	for _, test := range tests {
+		got := strings.TrimPrefix(Decompile(test.script, LintOptions{}), decompileHeader)
+		if got != test.expect {
+			t.Errorf("%q: got\n%s\nexpected\n%s", test.script, got, test.expect)
+		}
+	}
+}
+
+func TestDecompileStack(t *testing.T) {
+	got := Decompile("+n;", LintOptions{Stack: 2})
+	if !strings.Contains(got, "putn(s0 + s1)\n") {
+		t.Errorf("got\n%s", got)
+	}
+}
+
+func TestDecompileGoto(t *testing.T) {
+	// Both branches of "?" reach the "n", so the second gets there with a goto
+	got := Decompile("i?v>n;\n  >^", LintOptions{})
+	if !strings.Contains(got, "\tgoto B4\n") || !strings.Contains(got, "\tB4:\n") {
+		t.Errorf("got\n%s", got)
+	}
+}
@@ -0,0 +1,228 @@
+package starfish
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// StackDepth is the range of lengths the current stack can have when the fish reaches the cell at X, Y swimming
+// in Dir, before it executes it.
+type StackDepth struct {
+	X, Y      int
+	Dir       Direction
+	Min, Max  int
+	Unbounded bool // Set if there's no limit on the length, and Max should be ignored
+}
+
+// StackAnalysis is the result of AnalyzeStack.
+type StackAnalysis struct {
+	Depths      []StackDepth // Every cell and direction the fish can reach, sorted by row, column and direction
+	Diagnostics []Diagnostic
+}
+
+// unbounded is the upper bound of a depth with no limit.
+const unbounded = int(^uint(0) >> 1)
+
+// widenAfter is how many times a state's depth can grow before it's assumed to grow without bound.
+const widenAfter = 3
+
+// depthRange is the range of lengths the current stack can have.
+type depthRange struct {
+	min, max int
+}
+
+// add returns r with n values pushed, or popped if n is negative.
+func (r depthRange) add(n int) depthRange {
+	r.min += n
+	if r.max != unbounded {
+		r.max += n
+	}
+	if r.min < 0 {
+		r.min = 0
+	}
+	return r
+}
+
+// stackEffect returns how many values op needs on the stack and how many it leaves afterwards in their place.
+// known is false if the length of the stack afterwards can't be known statically.
+func stackEffect(op byte) (need, leave int, known bool) {
+	switch op {
+	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9', 'a', 'b', 'c', 'd', 'e', 'f', 'l', 'i', 'h', 'm', 's':
+		return 0, 1, true
+	case 'o', 'n', '?', '~', 'S':
+		return 1, 0, true
+	case '+', '-', '*', ',', '%', '=', ')', '(', 'g':
+		return 2, 1, true
+	case ':':
+		return 1, 2, true
+	case '$':
+		return 2, 2, true
+	case '@':
+		return 3, 3, true
+	case '}', '{':
+		return 1, 1, true
+	case 'p':
+		return 3, 0, true
+	case '.', 'C':
+		return 2, 0, true
+	case '[', 'F':
+		return 1, 0, false
+	case ']', 'I', 'D':
+		return 0, 0, false
+	}
+	return 0, 0, true
+}
+
+// AnalyzeStack works out how long the current stack can be at every cell the fish can reach in script, starting
+// with opts.Stack values, and reports instructions that must or may underflow and loops in which the stack can
+// grow without bound. The lengths are ranges, so a loop that pushes and pops the same number of values in
+// different branches may be reported as growing. After "[", "]", "I", "D" and "F", and at the start of a loop
+// that grows, the length is unknown, so what follows may be reported as possibly underflowing.
+func AnalyzeStack(script string, opts LintOptions) *StackAnalysis {
+	a := &StackAnalysis{}
+	box := loadScript(script, opts.Encoding)
+	if box.width == 0 {
+		return a
+	}
+	g := newFlowGraph(box)
+	depth := map[fishState]depthRange{g.start: {opts.Stack, opts.Stack}}
+	grew := make(map[fishState]int)
+	grows := make(map[point]bool)
+	queue := []fishState{g.start}
+	queued := map[fishState]bool{g.start: true}
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		queued[s] = false
+		out, ok := g.afterTick(s, depth[s])
+		if !ok {
+			continue
+		}
+		for _, n := range g.next[s] {
+			old, seen := depth[n]
+			r := out
+			if seen {
+				if r.min > old.min {
+					r.min = old.min
+				}
+				if r.max < old.max {
+					r.max = old.max
+				}
+				if r == old {
+					continue
+				}
+				if grew[n]++; grew[n] >= widenAfter {
+					if r.max > old.max && r.max != unbounded {
+						r.max = unbounded
+						grows[point{n.x, n.y}] = true
+					}
+					if r.min < old.min {
+						r.min = 0
+					}
+				}
+			}
+			depth[n] = r
+			if !queued[n] {
+				queued[n] = true
+				queue = append(queue, n)
+			}
+		}
+	}
+
+	type cellDir struct {
+		x, y int
+		dir  Direction
+	}
+	byCell := make(map[cellDir]depthRange)
+	seen := make(map[Diagnostic]bool)
+	report := func(d Diagnostic) {
+		if !seen[d] {
+			seen[d] = true
+			a.Diagnostics = append(a.Diagnostics, d)
+		}
+	}
+	for _, s := range g.order {
+		r, ok := depth[s]
+		if !ok {
+			continue // Only reached after an underflow
+		}
+		c := cellDir{s.x, s.y, s.dir}
+		if old, ok := byCell[c]; ok {
+			if old.min < r.min {
+				r.min = old.min
+			}
+			if old.max > r.max {
+				r.max = old.max
+			}
+		}
+		byCell[c] = r
+
+		op, ok := g.op(s)
+		if !ok || s.quote != 0 || s.deep {
+			continue
+		}
+		need, _, _ := stackEffect(op)
+		name := strconv.QuoteRune(rune(op))
+		switch {
+		case r.max < need:
+			report(newDiagnostic(Error, s.x, s.y, fmt.Sprintf("%s needs %s, but the stack has at most %d here", name, values(need), r.max)))
+		case r.min < need:
+			report(newDiagnostic(Warning, s.x, s.y, fmt.Sprintf("%s needs %s, but the stack may have only %d here", name, values(need), r.min)))
+		}
+	}
+	for p := range grows {
+		report(newDiagnostic(Info, p.x, p.y, "the stack can grow without bound in this loop"))
+	}
+
+	for c, r := range byCell {
+		d := StackDepth{X: c.x, Y: c.y, Dir: c.dir, Min: r.min, Max: r.max}
+		if r.max == unbounded {
+			d.Max, d.Unbounded = 0, true
+		}
+		a.Depths = append(a.Depths, d)
+	}
+	sort.Slice(a.Depths, func(i, j int) bool {
+		d, e := a.Depths[i], a.Depths[j]
+		return d.Y < e.Y || (d.Y == e.Y && (d.X < e.X || (d.X == e.X && d.Dir < e.Dir)))
+	})
+	sortDiagnostics(a.Diagnostics)
+	return a
+}
+
+// values returns "n value" or "n values".
+func values(n int) string {
+	if n == 1 {
+		return "1 value"
+	}
+	return strconv.Itoa(n) + " values"
+}
+
+// afterTick returns the range of lengths the stack can have after the fish executes the cell s is on, given the
+// range r it can have before. ok is false if the cell always underflows.
+func (g *flowGraph) afterTick(s fishState, r depthRange) (after depthRange, ok bool) {
+	op, isOp := g.op(s)
+	switch {
+	case s.quote != 0:
+		if isOp && op == s.quote {
+			return r, true
+		}
+		return r.add(1), true
+	case !isOp || s.deep:
+		return r, true
+	case op == '&':
+		// Pops if the register is empty and pushes if it's full, which can't be known statically
+		return depthRange{r.add(-1).min, r.add(1).max}, true
+	}
+	need, leave, known := stackEffect(op)
+	if r.max < need {
+		return r, false
+	}
+	if r.min < need {
+		r.min = need // Only the paths that don't underflow carry on
+	}
+	if !known {
+		return depthRange{0, unbounded}, true
+	}
+	return r.add(leave - need), true
+}
@@ -0,0 +1,59 @@
+package starfish
+
+import (
+	"reflect"
+	"testing"
+)
+
+var depthTests = []struct {
+	script string
+	stack  int
+	diags  []string
+}{
+	{"1n;", 0, nil},
+	{"n;", 0, []string{"1:1: error: 'n' needs 1 value, but the stack has at most 0 here"}},
+	{"+n;", 2, nil},
+	{"+n;", 1, []string{"1:1: error: '+' needs 2 values, but the stack has at most 1 here"}},
+	{"i?!1n;", 0, []string{"1:5: warning: 'n' needs 1 value, but the stack may have only 0 here"}},
+	{"1", 0, []string{"1:1: info: the stack can grow without bound in this loop"}},
+	{"1~", 0, nil},
+	{`"ab"$@;`, 1, nil},
+	{`"ab"$@;`, 0, []string{"1:6: error: '@' needs 3 values, but the stack has at most 2 here"}},
+	{"3[n;", 3, []string{"1:3: warning: 'n' needs 1 value, but the stack may have only 0 here"}},
+}
+
+func TestAnalyzeStack(t *testing.T) {
+	for _, test := range depthTests {
+		var got []string
+		for _, d := range AnalyzeStack(test.script, LintOptions{Stack: test.stack}).Diagnostics {
+			got = append(got, d.String())
+		}
+		if !reflect.DeepEqual(got, test.diags) {
+			t.Errorf("%q with %d values: got %q, expected %q", test.script, test.stack, got, test.diags)
+		}
+	}
+}
+
+func TestStackDepths(t *testing.T) {
+	got := AnalyzeStack("v\n1\n>n;", LintOptions{}).Depths
+	expected := []StackDepth{
+		{0, 0, Right, 0, 0, false},
+		{0, 1, Down, 0, 0, false},
+		{0, 2, Down, 1, 1, false},
+		{1, 2, Right, 1, 1, false},
+		{2, 2, Right, 0, 0, false},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got %v, expected %v", got, expected)
+	}
+	if d := AnalyzeStack("1", LintOptions{}).Depths; len(d) != 1 || !d[0].Unbounded || d[0].Min != 0 {
+		t.Errorf("growing loop: %v", d)
+	}
+}
+
+func TestLintCheckStack(t *testing.T) {
+	diags := Lint("n;", LintOptions{CheckStack: true})
+	if len(diags) != 1 || diags[0].Severity != Error {
+		t.Errorf("%v", diags)
+	}
+}
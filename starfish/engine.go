@@ -0,0 +1,256 @@
+package starfish
+
+import "math"
+
+// noOp marks a cell that doesn't hold an instruction in cells.ops.
+const noOp = 0xff
+
+// plain is true for the instructions that never move the fish, turn it, change its modes, write a cell or
+// wait. A straight run of them always executes the same way, so Run executes it without looking at the fish
+// between them.
+var plain = [256]bool{
+	' ': true, 0: true,
+	'0': true, '1': true, '2': true, '3': true, '4': true, '5': true, '6': true, '7': true, '8': true, '9': true,
+	'a': true, 'b': true, 'c': true, 'd': true, 'e': true, 'f': true,
+	'+': true, '-': true, '*': true, ',': true, '%': true, '=': true, ')': true, '(': true,
+	':': true, '~': true, '$': true, '@': true, '}': true, '{': true, 'r': true, 'l': true, '[': true, ']': true,
+	'I': true, 'D': true, '&': true, 'o': true, 'n': true, 'g': true, 'h': true, 'm': true, 's': true, 'F': true,
+}
+
+// needs holds how many values an instruction needs on the stack, for the instructions that can't fail or wait
+// once they have them, and is -1 for the others. Swim executes these without recovering from a fault. "&" is
+// counted as needing a value even when the register is full.
+var needs [256]int8
+
+func init() {
+	for i := range needs {
+		needs[i] = -1
+	}
+	for _, op := range " \x00><v^|_#/\\x`O;!\"'u0123456789abcdefrl" {
+		needs[op] = 0
+	}
+	for _, op := range "?:~}{&on" {
+		needs[op] = 1
+	}
+	for _, op := range "+-*=)($" {
+		needs[op] = 2
+	}
+	needs['@'] = 3
+}
+
+// deltas holds how far the fish moves along x and y in each Direction.
+var deltas = [4][2]int{Right: {1, 0}, Down: {0, 1}, Left: {-1, 0}, Up: {0, -1}}
+
+// checkEvery is how many ticks Run executes between checks of its context.
+const checkEvery = 1024
+
+// predecode fills in ops and runs for every cell of core.
+func (c *cells) predecode() {
+	for y, row := range c.core {
+		for x, v := range row {
+			op, ok := opcode(v)
+			if !ok {
+				op = noOp
+			}
+			c.ops[y*c.width+x] = op
+		}
+	}
+	// Each run is counted from the one after it, so count from the far end
+	for i := len(c.ops) - 1; i >= 0; i-- {
+		c.count(i%c.width, i/c.width, Right)
+		c.count(i%c.width, i/c.width, Down)
+	}
+	for i := range c.ops {
+		c.count(i%c.width, i/c.width, Left)
+		c.count(i%c.width, i/c.width, Up)
+	}
+}
+
+// decode updates ops and runs after the cell of core at x, y has been written. Only the runs leading up to it
+// are counted again.
+func (c *cells) decode(x, y int) {
+	op, ok := opcode(c.core[y][x])
+	if !ok {
+		op = noOp
+	}
+	c.ops[y*c.width+x] = op
+	for dir, d := range deltas {
+		c.count(x, y, Direction(dir))
+		for x, y := x-d[0], y-d[1]; c.inCore(x, y) && plain[c.ops[y*c.width+x]]; x, y = x-d[0], y-d[1] {
+			c.count(x, y, Direction(dir))
+		}
+	}
+}
+
+// count sets the run of plain instructions starting at the cell of core at x, y and going in dir, from the run
+// starting at the cell after it. The run stops at the edge of core.
+func (c *cells) count(x, y int, dir Direction) {
+	i := y*c.width + x
+	n := int32(0)
+	if plain[c.ops[i]] {
+		n = 1
+		if x, y := x+deltas[dir][0], y+deltas[dir][1]; c.inCore(x, y) {
+			n += c.runs[(y*c.width+x)*4+int(dir)]
+		}
+	}
+	c.runs[i*4+int(dir)] = n
+}
+
+// race swims like calling Swim in a loop, until the ><> executes ";", fails, or is interrupted, done is closed,
+// or max ticks have been executed if max is greater than 0. It returns how many ticks succeeded. Straight runs
+// of plain instructions are executed one after another from the predecoded cells, and the fish is only moved
+// at the end of each. Faults are recovered once per call rather than once per tick. Nothing is journaled,
+// traced, profiled or checked against breakpoints, so Run only uses race when none of those are set.
+func (cB *CodeBox) race(done <-chan struct{}, max int64) (ticks int64, end bool, err error) {
+	box := &cB.box
+	var x, y int // The cell being executed
+	var dir Direction
+	// In a run, the index in core of the cell being executed, how far the next one is, and how many are left
+	// after it. The ticks of the whole run are counted before it starts.
+	at, step, left := -1, 0, int64(0)
+	defer func() {
+		if at >= 0 {
+			x, y = at%box.width, at/box.width
+			ticks -= left + 1
+			cB.tick -= left
+		}
+		if rec := recover(); rec != nil {
+			switch r := rec.(type) {
+			case interrupted:
+				cB.fX, cB.fY, cB.fDir = x, y, dir
+				cB.tick--
+				err = r.err
+			case fault:
+				err = cB.fail(r, x, y, dir)
+			default:
+				panic(rec)
+			}
+		}
+	}()
+
+	var check int64
+	for max <= 0 || ticks < max {
+		if done != nil && ticks >= check {
+			select {
+			case <-done:
+				return ticks, false, nil
+			default:
+			}
+			check = ticks + checkEvery
+		}
+
+		x, y, dir = cB.fX, cB.fY, cB.fDir
+		var r byte
+		if cB.stringMode == 0 && !cB.deepSea && box.inCore(x, y) {
+			at = y*box.width + x
+			if n := int64(box.runs[at*4+int(dir)]); n > 0 {
+				if max > 0 && n > max-ticks {
+					n = max - ticks
+				}
+				cB.tick += n
+				ticks += n
+				dx, dy := deltas[dir][0], deltas[dir][1]
+				step = dy*box.width + dx
+				for left = n - 1; left >= 0; left-- {
+					if op := box.ops[at]; op > ' ' && !(cB.floats && cB.exeFloat(op)) {
+						cB.Exe(op)
+					}
+					at += step
+				}
+				at = -1
+				cB.fX, cB.fY = x+int(n-1)*dx, y+int(n-1)*dy
+				cB.Move()
+				continue
+			}
+			at = -1
+			cB.tick++
+			if r = box.ops[y*box.width+x]; r == noOp {
+				fishy(UnknownInstruction, nil)
+			}
+			end = cB.Exe(r)
+			cB.Move()
+		} else {
+			var isOp bool
+			r, isOp = box.op(x, y)
+			cB.tick++
+			end = cB.step(box.get(x, y), r, isOp)
+		}
+		ticks++
+		if end {
+			return ticks, true, cB.Flush()
+		}
+		if r == 'i' || r == 'S' {
+			check = ticks // Waiting may have been cut short by done
+		}
+	}
+	return ticks, false, nil
+}
+
+// exeFloat executes op like Exe for a CodeBox using Float64Numeric without a journal, outside deep sea mode,
+// working on the float64s of the stack directly for the instructions executed most. It returns false without
+// doing anything if op isn't one of them or the stack doesn't hold the float64s it needs, leaving op to Exe.
+func (cB *CodeBox) exeFloat(op byte) bool {
+	if uint(cB.p) >= uint(len(cB.stacks)) {
+		return false
+	}
+	s := cB.stacks[cB.p]
+	switch op {
+	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
+		s.put(Float(float64(op - '0')))
+	case 'a', 'b', 'c', 'd', 'e', 'f':
+		s.put(Float(float64(op - 'a' + 10)))
+	case 'l':
+		s.put(Float(float64(s.n)))
+	case ':':
+		if s.n < 1 {
+			return false
+		}
+		s.put(*s.top(0))
+	case '~':
+		if s.n < 1 {
+			return false
+		}
+		s.take()
+	case '?':
+		if s.n < 1 {
+			return false
+		}
+		if s.take().IsZero() {
+			cB.Move()
+		}
+	case '+', '-', '*', '=', ')', '(':
+		if s.n < 2 || s.top(0).kind != floatValue || s.top(1).kind != floatValue {
+			return false
+		}
+		x := math.Float64frombits(s.take().n)
+		v := s.top(0)
+		y := math.Float64frombits(v.n)
+		var z float64
+		switch op {
+		case '+':
+			z = y + x
+		case '-':
+			z = y - x
+		case '*':
+			z = y * x
+		case '=':
+			z = boolFloat(y == x)
+		case ')':
+			z = boolFloat(y > x)
+		case '(':
+			z = boolFloat(y < x)
+		}
+		v.n = math.Float64bits(z)
+	default:
+		return false
+	}
+	return true
+}
+
+// boolFloat returns 1 if b is true, or 0 if not.
+func boolFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,133 @@
+package starfish
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestRaceMatchesSwim checks Run, which races through runs of plain instructions, leaves the CodeBox as
+// calling Swim in a loop does.
+func TestRaceMatchesSwim(t *testing.T) {
+	tests := []struct {
+		script   string
+		maxTicks int64
+	}{
+		{`"olleh"ooooo;`, 0},
+		{"12345678+++++++n;", 0},
+		{"12345678+++++++n;", 5},     // Stops in the middle of a run
+		{"a>1-:?v;\n ^    <", 0},     // Loops, then ends
+		{"1234\n~~~~\n~~~~", 0},      // Fails in the middle of a run
+		{"5\"n\"80p1234~;", 0},       // Writes "n" over the "~" the run it's in is about to reach
+		{"v\n\n\n>\"a\"01p ;", 0},    // Writes outside the run it's in
+		{"\";\"a0p12", 0},            // Grows the codebox, then swims out of core to what it wrote
+		{"u12n O3n;", 0},             // Skips "12n" in deep sea mode
+		{"1k", 0},                    // Fails on an unknown instruction
+		{"1\"\x80\"\x80", 0},         // Pushes a non-ASCII character in a string, then fails on it
+		{"v;\n 1\n 2\n>^", 0},        // Runs downwards and upwards
+		{"1232[{}rl$@:&&DI]nnn;", 0}, // Moves values between stacks
+		{"00g01g02gnnn;\n\n ", 0},    // Reads cells
+	}
+	for _, test := range tests {
+		if test.maxTicks == 0 {
+			test.maxTicks = 1000 // In case a script doesn't end
+		}
+		var swimOut, runOut bytes.Buffer
+		swum := NewCodeBox(test.script, nil, false, WithOutput(&swimOut))
+		var swimTicks int64
+		var swimErr error
+		for swimTicks < test.maxTicks {
+			end, err := swum.Swim()
+			if err != nil {
+				swimErr = err
+				break
+			}
+			swimTicks++
+			if end {
+				break
+			}
+		}
+		swum.Flush()
+
+		raced := NewCodeBox(test.script, nil, false, WithOutput(&runOut))
+		res, err := raced.Run(context.Background(), RunOptions{MaxTicks: test.maxTicks})
+		if res.Ticks != swimTicks || raced.Tick() != swum.Tick() {
+			t.Errorf("%q: ran %d ticks, Tick %d; expected %d, %d", test.script, res.Ticks, raced.Tick(), swimTicks,
+				swum.Tick())
+		}
+		if !reflect.DeepEqual(err, swimErr) {
+			t.Errorf("%q: got error %v, expected %v", test.script, err, swimErr)
+		}
+		if runOut.String() != swimOut.String() {
+			t.Errorf("%q: wrote %q, expected %q", test.script, runOut.String(), swimOut.String())
+		}
+		if !reflect.DeepEqual(raced.Snapshot(), swum.Snapshot()) {
+			t.Errorf("%q: got\n%+v\nexpected\n%+v", test.script, raced.Snapshot(), swum.Snapshot())
+		}
+	}
+}
+
+// TestDecode checks writes to cells keep the predecoded runs as predecode would count them from scratch.
+func TestDecode(t *testing.T) {
+	box := newCells(strings.Split("12>34\n5 6 7\n89;ab", "\n"), Bytes, Float64Numeric{})
+	for _, w := range []struct {
+		x, y int
+		v    Value
+	}{
+		{2, 0, Int(' ')},
+		{1, 1, Int('v')},
+		{4, 2, Int(-1)},
+		{2, 2, Int('+')},
+		{0, 0, Value{}},
+		{1, 1, Int('?')},
+	} {
+		box.set(w.x, w.y, w.v)
+		fresh := box
+		fresh.ops, fresh.runs = make([]byte, len(box.ops)), make([]int32, len(box.runs))
+		fresh.predecode()
+		if !reflect.DeepEqual(box.ops, fresh.ops) || !reflect.DeepEqual(box.runs, fresh.runs) {
+			t.Fatalf("after writing %v to %d,%d: got %v %v, expected %v %v", w.v, w.x, w.y, box.ops, box.runs,
+				fresh.ops, fresh.runs)
+		}
+	}
+	if n := box.runs[(1*box.width+2)*4+int(Right)]; n != 3 {
+		t.Errorf("run from 2,1 right is %d cells, expected 3", n)
+	}
+}
+
+// TestFloatMatchesExe checks the instructions Swim and Run execute on float64s directly do what Exe does.
+func TestFloatMatchesExe(t *testing.T) {
+	stack := []Value{Float(math.NaN()), Float(-0.5), Float(2.5)}
+	for _, script := range []string{
+		"l:+:*n:1-n;",
+		"r:$-n::=n)n;",
+		"(n:(n;",       // Compares with NaN
+		"=n=n;",        // Compares with NaN
+		"::(n::)n;",    // Compares equal values
+		"r0?n~?nfa*n;", // Tests and drops from a reversed stack
+		"~~~~;",
+	} {
+		var fastOut, slowOut bytes.Buffer
+		fast := NewCodeBox(script, nil, false, WithStack(stack), WithOutput(&fastOut))
+		slow := NewCodeBox(script, nil, false, WithStack(stack), WithOutput(&slowOut), WithJournal(1))
+		for {
+			fastEnd, fastErr := fast.Swim()
+			slowEnd, slowErr := slow.Swim()
+			if fastEnd != slowEnd || !reflect.DeepEqual(fastErr, slowErr) {
+				t.Fatalf("%q: got %v, %v, expected %v, %v", script, fastEnd, fastErr, slowEnd, slowErr)
+			}
+			if !reflect.DeepEqual(fast.Snapshot().Stacks, slow.Snapshot().Stacks) {
+				t.Fatalf("%q: got %+v, expected %+v", script, fast.Snapshot().Stacks, slow.Snapshot().Stacks)
+			}
+			if fastEnd || fastErr != nil {
+				break
+			}
+		}
+		if fastOut.String() != slowOut.String() {
+			t.Errorf("%q: wrote %q, expected %q", script, fastOut.String(), slowOut.String())
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package starfish
+
+import (
+	"fmt"
+)
+
+// ErrorKind describes why a ><> stopped swimming with a RuntimeError.
+type ErrorKind byte
+
+const (
+	StackUnderflow ErrorKind = iota
+	UnknownInstruction
+	DivisionByZero
+	BadJump
+	FileError
+	BadCoordinate
+)
+
+var errorKindNames = [...]string{
+	StackUnderflow:     "stack underflow",
+	UnknownInstruction: "unknown instruction",
+	DivisionByZero:     "division by zero",
+	BadJump:            "bad jump target",
+	FileError:          "file error",
+	BadCoordinate:      "non-integer coordinate",
+}
+
+func (k ErrorKind) String() string {
+	if int(k) < len(errorKindNames) {
+		return errorKindNames[k]
+	}
+	return fmt.Sprintf("ErrorKind(%d)", byte(k))
+}
+
+func (d Direction) String() string {
+	switch d {
+	case Right:
+		return "right"
+	case Down:
+		return "down"
+	case Left:
+		return "left"
+	case Up:
+		return "up"
+	}
+	return fmt.Sprintf("Direction(%d)", byte(d))
+}
+
+// RuntimeError is returned by CodeBox.Swim when the ><> cannot execute the instruction it is on. It records
+// where the fish was, what it was doing, and a copy of the current stack at the time of the error.
+type RuntimeError struct {
+	Kind        ErrorKind
+	X, Y        int
+	Instruction byte  // 0 if Cell doesn't hold an ASCII character
+	Cell        Value // The value of the cell the fish was on
+	Dir         Direction
+	Stack       []Value
+	Err         error // The underlying error, if any (ex: from opening a file)
+}
+
+func (e *RuntimeError) Error() string {
+	msg := fmt.Sprintf("%v at %d,%d executing %s while swimming %v", e.Kind, e.X, e.Y, cellString(e.Cell), e.Dir)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error, if any.
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// fault is the value Exe and the Stack methods panic with. Swim recovers it and turns it into a
+// RuntimeError.
+type fault struct {
+	kind ErrorKind
+	err  error
+}
+
+// fishy panics with a fault of kind k.
+func fishy(k ErrorKind, err error) {
+	panic(fault{k, err})
+}
+
+// interrupted is the value Exe panics with when the context is done while it's waiting. Swim recovers it and
+// returns err without moving the fish.
+type interrupted struct {
+	err error
+}
@@ -0,0 +1,60 @@
+package starfish
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		script string
+		stack  []float64
+		kind   ErrorKind
+		x, y   int
+	}{
+		{"~", nil, StackUnderflow, 0, 0},
+		{" $", []float64{1}, StackUnderflow, 1, 0},
+		{"@", []float64{1, 2}, StackUnderflow, 0, 0},
+		{"{", nil, StackUnderflow, 0, 0},
+		{"]", nil, StackUnderflow, 0, 0},
+		{"D", nil, StackUnderflow, 0, 0},
+		{"R", nil, StackUnderflow, 0, 0},
+		{"[", []float64{1, 2}, StackUnderflow, 0, 0},
+		{"v\nz", nil, UnknownInstruction, 0, 1},
+		{"10%", nil, DivisionByZero, 2, 0},
+		{"9a.", nil, BadJump, 2, 0},
+		{"01-0.", nil, BadJump, 4, 0},
+		{"00C", nil, StackUnderflow, 2, 0},
+	}
+	for _, test := range tests {
+		cB := NewCodeBox(test.script, test.stack, false)
+		var err error
+		for i := 0; err == nil && i < 10; i++ {
+			_, err = cB.Swim()
+		}
+		var rErr *RuntimeError
+		if !errors.As(err, &rErr) {
+			t.Errorf("%q: expected a *RuntimeError, got %v", test.script, err)
+			continue
+		}
+		if rErr.Kind != test.kind || rErr.X != test.x || rErr.Y != test.y {
+			t.Errorf("%q: expected %v at %d,%d, got %v", test.script, test.kind, test.x, test.y, rErr)
+		}
+		if x, y := cB.Loc(); x != test.x || y != test.y {
+			t.Errorf("%q: fish moved to %d,%d after the error", test.script, x, y)
+		}
+	}
+}
+
+func TestRuntimeErrorStack(t *testing.T) {
+	cB := NewCodeBox("$", []float64{TESTVALUE1}, false)
+	_, err := cB.Swim()
+	rErr, ok := err.(*RuntimeError)
+	if !ok || rErr.Instruction != '$' || rErr.Dir != Right || len(rErr.Stack) != 1 || rErr.Stack[0] != Float(TESTVALUE1) {
+		t.Fatal(err)
+	}
+	rErr.Stack[0] = Float(TESTVALUE2)
+	if cB.Stack()[0] != TESTVALUE1 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,175 @@
+package starfish
+
+import (
+	"strings"
+)
+
+// fishState is what static analysis knows about the fish as it reaches a cell: where it is, where it's swimming,
+// the quote it's in string mode for, if any, and whether it's in deep sea mode. Stacks aren't tracked.
+type fishState struct {
+	x, y  int
+	dir   Direction
+	quote byte
+	deep  bool
+}
+
+// flowGraph is every state the fish can reach from the top-left cell swimming right, following every direction
+// that "x", "?" and "`" can send it in. Jumps and calls can't be followed, as their targets are on the stack.
+type flowGraph struct {
+	box     cells
+	start   fishState
+	next    map[fishState][]fishState // Successors of every reached state
+	order   []fishState               // Reached states, in the order they were found
+	dynamic []fishState               // Reached states that jump to a target on the stack: ".", "C" and "R"
+}
+
+// starOnly holds the instructions *><> adds to ><>.
+const starOnly = "Ohmsu`SFCRID"
+
+// validOp returns true if op is an instruction, or a no-op.
+func validOp(op byte) bool {
+	return op == 0 || strings.IndexByte(" ><v^|_#/\\x;\"'0123456789abcdef&onr+-*,%=)(!?.:~$@}{][lgpi"+starOnly, op) >= 0
+}
+
+// loadScript returns the cells of script as NewCodeBox would lay it out.
+func loadScript(script string, e Encoding) cells {
+	script = strings.Replace(script, "\r", "", -1)
+	return newCells(strings.Split(script, "\n"), e, Float64Numeric{})
+}
+
+// newFlowGraph explores every state the fish can reach in box.
+func newFlowGraph(box cells) *flowGraph {
+	g := &flowGraph{box: box, start: fishState{dir: Right}, next: make(map[fishState][]fishState)}
+	queue := []fishState{g.start}
+	g.next[g.start] = nil
+	for len(queue) > 0 {
+		s := queue[0]
+		queue = queue[1:]
+		g.order = append(g.order, s)
+		next, dynamic := g.successors(s)
+		if dynamic {
+			g.dynamic = append(g.dynamic, s)
+		}
+		g.next[s] = next
+		for _, n := range next {
+			if _, ok := g.next[n]; !ok {
+				g.next[n] = nil
+				queue = append(queue, n)
+			}
+		}
+	}
+	return g
+}
+
+// op returns the instruction in the cell s is on. The bool is false if the cell doesn't hold an instruction.
+func (g *flowGraph) op(s fishState) (byte, bool) {
+	op, ok := opcode(g.box.get(s.x, s.y))
+	return op, ok && validOp(op)
+}
+
+// move returns s moved one cell, wrapping around the edges of the codebox as CodeBox.Move does.
+func (g *flowGraph) move(s fishState) fishState {
+	switch s.dir {
+	case Right:
+		if s.x++; s.x > g.box.maxX {
+			s.x = g.box.minX
+		}
+	case Down:
+		if s.y++; s.y > g.box.maxY {
+			s.y = g.box.minY
+		}
+	case Left:
+		if s.x--; s.x < g.box.minX {
+			s.x = g.box.maxX
+		}
+	case Up:
+		if s.y--; s.y < g.box.minY {
+			s.y = g.box.maxY
+		}
+	}
+	return s
+}
+
+// turn returns s swimming in dir, moved one cell.
+func (g *flowGraph) turn(s fishState, dir Direction) fishState {
+	s.dir = dir
+	return g.move(s)
+}
+
+// successors returns the states the fish can be in after executing the cell s is on. dynamic is set if it also
+// jumps somewhere that can't be known statically.
+func (g *flowGraph) successors(s fishState) (next []fishState, dynamic bool) {
+	op, ok := g.op(s)
+	if s.quote != 0 {
+		if ok && op == s.quote {
+			s.quote = 0
+		}
+		return []fishState{g.move(s)}, false
+	}
+	if !ok {
+		if s.deep {
+			return []fishState{g.move(s)}, false
+		}
+		return nil, false // Fails with an unknown instruction
+	}
+
+	switch op {
+	case '>':
+		return []fishState{g.turn(s, Right)}, false
+	case 'v':
+		return []fishState{g.turn(s, Down)}, false
+	case '<':
+		return []fishState{g.turn(s, Left)}, false
+	case '^':
+		return []fishState{g.turn(s, Up)}, false
+	case '|':
+		return []fishState{g.turn(s, [...]Direction{Left, Down, Right, Up}[s.dir])}, false
+	case '_':
+		return []fishState{g.turn(s, [...]Direction{Right, Up, Left, Down}[s.dir])}, false
+	case '#':
+		return []fishState{g.turn(s, [...]Direction{Left, Up, Right, Down}[s.dir])}, false
+	case '/':
+		return []fishState{g.turn(s, [...]Direction{Up, Left, Down, Right}[s.dir])}, false
+	case '\\':
+		return []fishState{g.turn(s, [...]Direction{Down, Right, Up, Left}[s.dir])}, false
+	case 'x':
+		return []fishState{g.turn(s, Right), g.turn(s, Down), g.turn(s, Left), g.turn(s, Up)}, false
+	case '`':
+		// Where the hook sends the fish depends on how it got here, so follow both ways
+		if s.dir == Down || s.dir == Up {
+			return []fishState{g.turn(s, Left), g.turn(s, Right)}, false
+		}
+		return []fishState{g.turn(s, Up), g.turn(s, Down)}, false
+	case 'O':
+		s.deep = false
+		return []fishState{g.move(s)}, false
+	}
+	if s.deep {
+		return []fishState{g.move(s)}, false
+	}
+
+	switch op {
+	case ';':
+		return nil, false
+	case '"', '\'':
+		s.quote = op
+	case '!':
+		return []fishState{g.move(g.move(s))}, false
+	case '?':
+		return []fishState{g.move(s), g.move(g.move(s))}, false
+	case '.', 'C', 'R':
+		return nil, true
+	case 'u':
+		s.deep = true
+	}
+	return []fishState{g.move(s)}, false
+}
+
+// reached returns every cell the fish can reach.
+func (g *flowGraph) reached() map[point]bool {
+	cells := make(map[point]bool)
+	for _, s := range g.order {
+		cells[point{s.x, s.y}] = true
+	}
+	return cells
+}
@@ -0,0 +1,271 @@
+package starfish
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrFilesDisabled is the error behind a FileError when "F" is used on a CodeBox without a FileSystem.
+var ErrFilesDisabled = errors.New("file access is disabled")
+
+// ErrInvalidPath is returned by MemFS and DirFS for names that aren't clean, slash-separated, relative paths.
+var ErrInvalidPath = errors.New("invalid path")
+
+// FileSystem is the filesystem "F" opens files in. Like io/fs, names are slash-separated and relative, with no
+// "." or ".." elements.
+type FileSystem interface {
+	// Open opens the named file for reading.
+	Open(name string) (io.ReadCloser, error)
+	// WriteFile replaces the contents of the named file with data, creating it if needed.
+	WriteFile(name string, data []byte) error
+}
+
+// WithFileSystem sets the FileSystem "F" uses. By default a CodeBox uses the host's filesystem with no
+// restrictions, so only trusted scripts should run without it; a nil fsys turns "F" off entirely.
+func WithFileSystem(fsys FileSystem) Option {
+	return func(cB *CodeBox) {
+		cB.fsys = fsys
+	}
+}
+
+// validPath reports whether name is a clean, slash-separated, relative path.
+func validPath(name string) bool {
+	return name != "" && name != "." && !strings.HasPrefix(name, "/") && !strings.Contains(name, "\\") &&
+		path.Clean(name) == name && name != ".." && !strings.HasPrefix(name, "../")
+}
+
+// hostFS is the default FileSystem. It opens any path the host can reach, as "F" always has.
+type hostFS struct{}
+
+func (hostFS) Open(name string) (io.ReadCloser, error) {
+	return os.Open(name)
+}
+
+func (hostFS) WriteFile(name string, data []byte) error {
+	return ioutil.WriteFile(name, data, 0644)
+}
+
+// DirFS is a FileSystem for the files inside one directory of the host. Names that would leave the directory,
+// including through symbolic links, are rejected. A file is checked again once it's open, so a link swapped in
+// while it's being opened can't be used to reach a file outside the directory either. At worst, a swap while a
+// new file is being created leaves it empty outside the directory.
+type DirFS string
+
+func (dir DirFS) resolve(name string) (string, error) {
+	if !validPath(name) {
+		return "", &os.PathError{Op: "open", Path: name, Err: ErrInvalidPath}
+	}
+	root, err := filepath.EvalSymlinks(string(dir))
+	if err != nil {
+		return "", err
+	}
+	full := filepath.Join(root, filepath.FromSlash(name))
+	real, err := filepath.EvalSymlinks(full)
+	if os.IsNotExist(err) {
+		// The file doesn't exist yet, so check the directory it would be created in. A dangling symbolic link
+		// would be followed when the file is created, wherever it points, so it's rejected.
+		if fi, lerr := os.Lstat(full); lerr == nil && fi.Mode()&os.ModeSymlink != 0 {
+			return "", &os.PathError{Op: "open", Path: name, Err: ErrInvalidPath}
+		}
+		real, err = filepath.EvalSymlinks(filepath.Dir(full))
+		real = filepath.Join(real, filepath.Base(full))
+	}
+	if err != nil {
+		return "", err
+	}
+	if real != root && !strings.HasPrefix(real, root+string(filepath.Separator)) {
+		return "", &os.PathError{Op: "open", Path: name, Err: ErrInvalidPath}
+	}
+	return real, nil
+}
+
+// open opens the named file inside dir with flag.
+func (dir DirFS) open(name string, flag int) (*os.File, error) {
+	real, err := dir.resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(real, flag, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err = dir.check(name, f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// check returns an error unless f is the file name resolves to inside dir, as it won't be if a symbolic link was
+// swapped in after name was resolved to open f.
+func (dir DirFS) check(name string, f *os.File) error {
+	opened, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	real, err := dir.resolve(name)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(real)
+	if err != nil {
+		return err
+	}
+	if !os.SameFile(opened, fi) {
+		return &os.PathError{Op: "open", Path: name, Err: ErrInvalidPath}
+	}
+	return nil
+}
+
+// Open opens the named file inside dir for reading.
+func (dir DirFS) Open(name string) (io.ReadCloser, error) {
+	f, err := dir.open(name, os.O_RDONLY)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// WriteFile replaces the contents of the named file inside dir with data. New files are created with 0644
+// permissions.
+func (dir DirFS) WriteFile(name string, data []byte) error {
+	// The file is only truncated once it's known to be inside dir
+	f, err := dir.open(name, os.O_WRONLY|os.O_CREATE)
+	if err != nil {
+		return err
+	}
+	if err = f.Truncate(0); err == nil {
+		_, err = f.Write(data)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// MemFS is an in-memory FileSystem. The zero value is an empty filesystem ready to use, and it's safe to share
+// between CodeBoxes.
+type MemFS struct {
+	mu    sync.Mutex
+	files map[string][]byte
+}
+
+// Open opens the named file for reading.
+func (m *MemFS) Open(name string) (io.ReadCloser, error) {
+	data, err := m.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+// ReadFile returns a copy of the contents of the named file.
+func (m *MemFS) ReadFile(name string) ([]byte, error) {
+	if !validPath(name) {
+		return nil, &os.PathError{Op: "open", Path: name, Err: ErrInvalidPath}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.files[name]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	return append([]byte(nil), data...), nil
+}
+
+// WriteFile replaces the contents of the named file with a copy of data.
+func (m *MemFS) WriteFile(name string, data []byte) error {
+	if !validPath(name) {
+		return &os.PathError{Op: "write", Path: name, Err: ErrInvalidPath}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.files == nil {
+		m.files = make(map[string][]byte)
+	}
+	m.files[name] = append([]byte(nil), data...)
+	return nil
+}
+
+// Names returns the names of every file in m, sorted.
+func (m *MemFS) Names() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	names := make([]string, 0, len(m.files))
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// openFile is a file opened by "F".
+type openFile struct {
+	name string
+	rc   io.ReadCloser
+	cr   *countingReader
+	r    *bufio.Reader
+}
+
+// newOpenFile returns rc as the file name opened by "F".
+func newOpenFile(name string, rc io.ReadCloser) *openFile {
+	cr := &countingReader{r: rc}
+	return &openFile{name, rc, cr, bufio.NewReader(cr)}
+}
+
+// offset returns how many bytes "i" has read from f.
+func (f *openFile) offset() int64 {
+	return f.cr.n - int64(f.r.Buffered())
+}
+
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// fileOp implements "F". With no file open, the popped bytes name a file to open for reading, which is created
+// if it doesn't exist. With a file open, the popped bytes replace its contents and it's closed.
+func (cB *CodeBox) fileOp() {
+	count := cB.Pop().int()
+	bData := cB.stack().getBytes(count)
+	if cB.fsys == nil {
+		fishy(FileError, ErrFilesDisabled)
+	}
+	if cB.file != nil {
+		f := cB.file
+		cB.file = nil
+		f.rc.Close()
+		if err := cB.fsys.WriteFile(f.name, bData); err != nil {
+			fishy(FileError, err)
+		}
+		return
+	}
+
+	name := string(bData)
+	rc, err := cB.fsys.Open(name)
+	if os.IsNotExist(err) {
+		if err = cB.fsys.WriteFile(name, nil); err == nil {
+			rc, err = cB.fsys.Open(name)
+		}
+	}
+	if err != nil {
+		fishy(FileError, err)
+	}
+	cB.file = newOpenFile(name, rc)
+}
@@ -0,0 +1,111 @@
+package starfish
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// runFile runs script to completion and returns the error it stops with, if any.
+func runFile(script string, fsys FileSystem) (*CodeBox, error) {
+	cB := NewCodeBox(script, nil, false, WithFileSystem(fsys))
+	var (
+		end bool
+		err error
+	)
+	for i := 0; !end && err == nil && i < 100; i++ {
+		end, err = cB.Swim()
+	}
+	return cB, err
+}
+
+func TestMemFS(t *testing.T) {
+	fsys := new(MemFS)
+	fsys.WriteFile("a", []byte("q"))
+	cB, err := runFile(`"a"1Fi"xy"2F;`, fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := cB.Stack(); len(s) != 1 || s[0] != 'q' {
+		t.Errorf("expected to read 'q', got %v", s)
+	}
+	if data, _ := fsys.ReadFile("a"); string(data) != "xy" {
+		t.Errorf("expected %q, got %q", "xy", data)
+	}
+
+	if _, err = runFile(`"b"1F"z"1F;`, fsys); err != nil {
+		t.Fatal(err)
+	}
+	if names := fsys.Names(); len(names) != 2 || names[1] != "b" {
+		t.Errorf("expected a new file b, got %v", names)
+	}
+}
+
+func TestFilesDisabled(t *testing.T) {
+	_, err := runFile(`"a"1F;`, nil)
+	if rErr, ok := err.(*RuntimeError); !ok || rErr.Kind != FileError || !errors.Is(err, ErrFilesDisabled) {
+		t.Fatal(err)
+	}
+}
+
+func TestDirFS(t *testing.T) {
+	root, err := ioutil.TempDir("", "starfish")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	outside, err := ioutil.TempDir("", "starfish")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+	os.Mkdir(filepath.Join(root, "sub"), 0755)
+	os.Symlink(outside, filepath.Join(root, "link"))
+	os.Symlink(filepath.Join("..", filepath.Base(outside), "x"), filepath.Join(root, "dangling"))
+
+	if _, err = runFile(`"sub/f"5F"hi"2F;`, DirFS(root)); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(filepath.Join(root, "sub", "f"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm()&0022 != 0 {
+		t.Errorf("file was created with permissions %v", info.Mode().Perm())
+	}
+	if _, err = runFile(`"sub/f"5F"a"1F;`, DirFS(root)); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := ioutil.ReadFile(filepath.Join(root, "sub", "f")); string(data) != "a" {
+		t.Errorf("expected the file to be replaced with %q, got %q", "a", data)
+	}
+
+	for _, script := range []string{`"../x"4F;`, `"/tmp/x"6F;`, `"link/x"6F;`, `"dangling"8F"hi"2F;`} {
+		if _, err = runFile(script, DirFS(root)); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("%s: expected ErrInvalidPath, got %v", script, err)
+		}
+	}
+	if files, _ := ioutil.ReadDir(outside); len(files) != 0 {
+		t.Error("a file was created outside the root")
+	}
+
+	// A file opened through a link swapped in after the name was resolved
+	ioutil.WriteFile(filepath.Join(outside, "secret"), nil, 0644)
+	f, err := os.Open(filepath.Join(outside, "secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = DirFS(root).check("sub/f", f); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("expected ErrInvalidPath for a swapped file, got %v", err)
+	}
+	if f, err = os.Open(filepath.Join(root, "sub", "f")); err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err = DirFS(root).check("sub/f", f); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,182 @@
+package starfish
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sync"
+	"unicode/utf8"
+)
+
+// Encoding selects how a CodeBox turns its script and input into values, and values into output.
+type Encoding byte
+
+const (
+	Bytes Encoding = iota // Every byte is one cell or value, and "o" writes the low byte of a value
+	UTF8                  // Every UTF-8 encoded code point is one cell or value, and "o" encodes values as UTF-8
+)
+
+// WithScriptEncoding sets how NewCodeBox splits the script into cells. By default every byte is a cell, so a
+// character such as "é" takes up two cells.
+func WithScriptEncoding(e Encoding) Option {
+	return func(cB *CodeBox) {
+		cB.scriptEnc = e
+	}
+}
+
+// WithInputEncoding sets whether "i" reads bytes or UTF-8 encoded code points. By default it reads bytes.
+// Invalid UTF-8 is read as utf8.RuneError.
+func WithInputEncoding(e Encoding) Option {
+	return func(cB *CodeBox) {
+		cB.inEnc = e
+	}
+}
+
+// WithOutputEncoding sets whether "o" writes values as raw bytes or encodes them as UTF-8. By default they're
+// encoded as UTF-8. Fractions are dropped, and values that aren't valid code points are written as
+// utf8.RuneError.
+func WithOutputEncoding(e Encoding) Option {
+	return func(cB *CodeBox) {
+		cB.outEnc = e
+	}
+}
+
+// InputMode selects what "i" does when no input is ready yet.
+type InputMode byte
+
+const (
+	Polling  InputMode = iota // "i" pushes -1 if no input is ready, for interactive and real-time programs
+	Blocking                  // "i" waits for the next input, and pushes -1 only at the end of the input
+)
+
+// WithInputMode sets what "i" does when no input is ready yet. By default a CodeBox polls. In blocking mode,
+// waiting is cut short when the context given to CodeBox.Run is done.
+func WithInputMode(m InputMode) Option {
+	return func(cB *CodeBox) {
+		cB.inMode = m
+	}
+}
+
+// WithInput sets the reader "i" takes its input from. By default a CodeBox reads from os.Stdin, but only once
+// the ><> first executes "i".
+func WithInput(r io.Reader) Option {
+	return func(cB *CodeBox) {
+		cB.in = r
+	}
+}
+
+// WithOutput sets the writer "o" and "n" write to. By default a CodeBox writes to os.Stdout. Output is
+// buffered, and flushed when the ><> reads input, sleeps, ends, or fails.
+func WithOutput(w io.Writer) Option {
+	return func(cB *CodeBox) {
+		cB.outW = w
+		cB.out = bufio.NewWriter(w)
+	}
+}
+
+// readValue reads one byte or code point from r, as selected by e.
+func readValue(r *bufio.Reader, e Encoding) (rune, error) {
+	if e == UTF8 {
+		c, _, err := r.ReadRune()
+		return c, err
+	}
+	b, err := r.ReadByte()
+	return rune(b), err
+}
+
+// feed reads r into c until r returns an error, then closes c.
+func feed(r io.Reader, c chan<- rune, e Encoding) {
+	br := bufio.NewReader(r)
+	for {
+		v, err := readValue(br, e)
+		if err != nil {
+			close(c)
+			return
+		}
+		c <- v
+	}
+}
+
+// input is the input "i" reads from, shared by a CodeBox and its clones. The goroutine feeding it is only
+// started once one of them first reads from it.
+type input struct {
+	r    io.Reader
+	enc  Encoding
+	once sync.Once
+	c    chan rune
+}
+
+// values returns the channel the input is fed to, starting the goroutine feeding it if it isn't running yet.
+func (in *input) values() <-chan rune {
+	in.once.Do(func() {
+		if in.r == nil {
+			in.r = os.Stdin
+		}
+		in.c = make(chan rune, 1024)
+		go feed(in.r, in.c, in.enc)
+	})
+	return in.c
+}
+
+// source returns the CodeBox's input, making it the first time it's needed.
+func (cB *CodeBox) source() *input {
+	if cB.input == nil {
+		cB.input = &input{r: cB.in, enc: cB.inEnc}
+	}
+	return cB.input
+}
+
+// readInput returns the next value of input. The bool is false if no value is available, either because the
+// input has ended or, when polling, because none is ready yet. The goroutine feeding the CodeBox's input is
+// started by the first call.
+func (cB *CodeBox) readInput() (rune, bool) {
+	c := cB.source().values()
+	if cB.inMode == Blocking {
+		ctx := cB.context()
+		select {
+		case v, ok := <-c:
+			return v, ok
+		case <-ctx.Done():
+			panic(interrupted{ctx.Err()})
+		}
+	}
+	select {
+	case v, ok := <-c:
+		return v, ok
+	default:
+		return 0, false
+	}
+}
+
+// writer returns the CodeBox's buffered output.
+func (cB *CodeBox) writer() *bufio.Writer {
+	if cB.out == nil {
+		cB.outW = os.Stdout
+		cB.out = bufio.NewWriter(os.Stdout)
+	}
+	return cB.out
+}
+
+// writeValue implements "o".
+func (cB *CodeBox) writeValue(v Value) {
+	i := v.Int64()
+	if cB.outEnc == Bytes {
+		cB.writer().WriteByte(byte(i))
+		cB.traceByte(byte(i))
+		return
+	}
+	r := utf8.RuneError
+	if i >= 0 && i <= utf8.MaxRune {
+		r = rune(i)
+	}
+	cB.writer().WriteRune(r)
+	cB.traceRune(r)
+}
+
+// Flush writes any buffered output to the CodeBox's output writer.
+func (cB *CodeBox) Flush() error {
+	if cB.out == nil {
+		return nil
+	}
+	return cB.out.Flush()
+}
@@ -0,0 +1,132 @@
+package starfish
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// CATSCRIPT waits for 3 bytes of input, then outputs them.
+const CATSCRIPT = `>i:0(?vl3=?v
+^~    <    r
+           o
+           o
+           o
+           ;`
+
+func TestInputOutput(t *testing.T) {
+	var out bytes.Buffer
+	runscript(CATSCRIPT, nil, false, WithInput(strings.NewReader("fsh")), WithOutput(&out))
+	if out.String() != "fsh" {
+		t.Errorf("expected %q, got %q", "fsh", out.String())
+	}
+
+	out.Reset()
+	runscript("73*n;", nil, false, WithOutput(&out))
+	if out.String() != "21" {
+		t.Errorf("expected %q, got %q", "21", out.String())
+	}
+}
+
+func TestOutputFlushedOnError(t *testing.T) {
+	var out bytes.Buffer
+	cB := NewCodeBox(`"ih"oo~`, nil, false, WithOutput(&out))
+	var err error
+	for end := false; !end && err == nil; end, err = cB.Swim() {
+	}
+	if err == nil || out.String() != "hi" {
+		t.Errorf("expected an error and %q, got %v and %q", "hi", err, out.String())
+	}
+}
+
+func TestConcurrentCodeBoxes(t *testing.T) {
+	inputs := []string{"abc", "def", "ghi", "jkl", "mno", "pqr", "stu", "vwx"}
+	outs := make([]bytes.Buffer, len(inputs))
+	var wg sync.WaitGroup
+	for i := range inputs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			runscript(CATSCRIPT, nil, false, WithInput(strings.NewReader(inputs[i])), WithOutput(&outs[i]))
+		}(i)
+	}
+	wg.Wait()
+	for i := range inputs {
+		if outs[i].String() != inputs[i] {
+			t.Errorf("expected %q, got %q", inputs[i], outs[i].String())
+		}
+	}
+}
+
+func TestEncodings(t *testing.T) {
+	cB := runscript(`"é";`, nil, false)
+	if s := cB.Stack(); len(s) != 2 || s[0] != 0xC3 || s[1] != 0xA9 {
+		t.Errorf("expected the bytes of é, got %v", s)
+	}
+	cB = runscript(`"é";`, nil, false, WithScriptEncoding(UTF8))
+	if s := cB.Stack(); len(s) != 1 || s[0] != 'é' {
+		t.Errorf("expected the code point of é, got %v", s)
+	}
+	if w, _ := cB.Size(); w != 4 {
+		t.Errorf("expected a width of 4, got %d", w)
+	}
+
+	var out bytes.Buffer
+	runscript(`"é"o;`, nil, false, WithScriptEncoding(UTF8), WithOutput(&out))
+	if out.String() != "é" {
+		t.Errorf("expected %q, got %q", "é", out.String())
+	}
+	out.Reset()
+	runscript(`"é"o;`, nil, false, WithScriptEncoding(UTF8), WithOutputEncoding(Bytes), WithOutput(&out))
+	if out.String() != "\xe9" {
+		t.Errorf("expected %q, got %q", "\xe9", out.String())
+	}
+
+	out.Reset()
+	runscript(CATSCRIPT, nil, false, WithInput(strings.NewReader("aéb")), WithInputEncoding(UTF8), WithOutput(&out))
+	if out.String() != "aéb" {
+		t.Errorf("expected %q, got %q", "aéb", out.String())
+	}
+}
+
+func TestBlockingInput(t *testing.T) {
+	r, w := io.Pipe()
+	go func() {
+		w.Write([]byte("a"))
+		time.Sleep(20 * time.Millisecond)
+		w.Write([]byte("bc"))
+		w.Close()
+	}()
+	var out bytes.Buffer
+	runscript("iiiinrooo;", nil, false, WithInput(r), WithInputMode(Blocking), WithOutput(&out))
+	if out.String() != "-1abc" {
+		t.Errorf("expected %q, got %q", "-1abc", out.String())
+	}
+}
+
+func TestPollingInput(t *testing.T) {
+	r, _ := io.Pipe()
+	var out bytes.Buffer
+	runscript("in;", nil, false, WithInput(r), WithOutput(&out))
+	if out.String() != "-1" {
+		t.Errorf("expected %q, got %q", "-1", out.String())
+	}
+}
+
+func TestBlockingInputCancelled(t *testing.T) {
+	r, _ := io.Pipe()
+	cB := NewCodeBox(" i;", nil, false, WithInput(r), WithInputMode(Blocking))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	res, err := cB.Run(ctx, RunOptions{})
+	if err != context.DeadlineExceeded || res.Reason != Cancelled || res.Ticks != 1 {
+		t.Errorf("expected a cancelled run after 1 tick, got %v after %d ticks: %v", res.Reason, res.Ticks, err)
+	}
+	if x, y := cB.Loc(); x != 1 || y != 0 || cB.StackLength() != 0 {
+		t.Errorf("expected the fish to wait on \"i\", got %d,%d with stack %v", x, y, cB.Stack())
+	}
+}
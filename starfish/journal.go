@@ -0,0 +1,279 @@
+package starfish
+
+import (
+	"errors"
+)
+
+// ErrNotJournaled is returned by CodeBox.JumpTo for ticks that are too old to rewind to.
+var ErrNotJournaled = errors.New("tick is no longer in the journal")
+
+// ErrEnded is returned by CodeBox.JumpTo when the ><> ends before reaching the tick.
+var ErrEnded = errors.New("the ><> has ended")
+
+// WithJournal makes the CodeBox keep a journal of what each tick changes, so that it can be stepped backward
+// through at least its last n ticks. Only the changes are recorded, which is usually a few values per tick.
+//
+// Rewinding restores the fish, its stacks and the codebox. It can't take back output, input read by "i", files
+// written by "F", or the position of a file being read, and it doesn't rewind the clock or "x".
+func WithJournal(n int) Option {
+	return func(cB *CodeBox) {
+		if n > 0 {
+			cB.journal = &journal{limit: n}
+		} else {
+			cB.journal = nil
+		}
+	}
+}
+
+type changeOp byte
+
+const (
+	pushed       changeOp = iota // A value was pushed onto s
+	popped                       // v was popped from s
+	reversed                     // s was reversed
+	swappedTwo                   // "$" was applied to s
+	swappedThree                 // "@" was applied to s
+	prepended                    // A value was added to the start of s
+	removedFirst                 // v was removed from the start of s
+	truncated                    // vals were removed from the end of s
+	appended                     // n values were added to the end of s
+	registerSet                  // The register of s was v, and filled if n is 1
+	stacksSet                    // The CodeBox's stacks were stacks
+	cellSet                      // The cell at n, y held v, and the codebox had bounds if set
+)
+
+// change is one change to a CodeBox, with what's needed to undo it.
+type change struct {
+	op     changeOp
+	s      *Stack
+	v      Value
+	n, y   int
+	vals   []Value
+	stacks []*Stack
+	bounds *[4]int
+}
+
+// record holds the state of the fish before a tick, and where the tick's changes start in the journal.
+type record struct {
+	fX, fY      int
+	fDir        Direction
+	wasLeft     bool
+	escapedHook bool
+	deepSea     bool
+	stringMode  byte
+	p           int
+	start       int
+}
+
+// journal records the changes made by recent ticks. A nil journal records nothing.
+type journal struct {
+	limit   int
+	records []record
+	changes []change
+}
+
+// begin starts recording a tick of cB.
+func (j *journal) begin(cB *CodeBox) {
+	if j == nil {
+		return
+	}
+	if len(j.records) >= 2*j.limit {
+		j.trim()
+	}
+	j.records = append(j.records, record{
+		fX: cB.fX, fY: cB.fY, fDir: cB.fDir, wasLeft: cB.wasLeft, escapedHook: cB.escapedHook,
+		deepSea: cB.deepSea, stringMode: cB.stringMode, p: cB.p, start: len(j.changes),
+	})
+}
+
+// trim forgets all but the last limit ticks.
+func (j *journal) trim() {
+	drop := len(j.records) - j.limit
+	cut := j.records[drop].start
+	n := copy(j.records, j.records[drop:])
+	j.records = j.records[:n]
+	for i := range j.records {
+		j.records[i].start -= cut
+	}
+	n = copy(j.changes, j.changes[cut:])
+	for i := n; i < len(j.changes); i++ {
+		j.changes[i] = change{} // Let the garbage collector have anything only the dropped changes held
+	}
+	j.changes = j.changes[:n]
+}
+
+// add records c as part of the current tick.
+func (j *journal) add(c change) {
+	if j != nil && len(j.records) > 0 {
+		j.changes = append(j.changes, c)
+	}
+}
+
+// saveStacks records stacks before the CodeBox's list of stacks is changed.
+func (j *journal) saveStacks(stacks []*Stack) {
+	if j != nil {
+		j.add(change{op: stacksSet, stacks: append([]*Stack(nil), stacks...)})
+	}
+}
+
+// discard forgets the current tick, which must not have changed anything.
+func (j *journal) discard() {
+	if j != nil {
+		j.records = j.records[:len(j.records)-1]
+	}
+}
+
+// reset forgets every tick.
+func (j *journal) reset() {
+	if j != nil {
+		j.records = j.records[:0]
+		j.changes = j.changes[:0]
+	}
+}
+
+// boolInt returns 1 if b is true, or 0 if not.
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// newStack returns a Stack populated with s that records its changes in cB's journal.
+func (cB *CodeBox) newStack(s []Value) *Stack {
+	st := NewStack(s)
+	st.log = cB.journal
+	return st
+}
+
+// setCell implements the write done by "p".
+func (cB *CodeBox) setCell(x, y int, v Value) {
+	if cB.journal != nil {
+		c := change{op: cellSet, n: x, y: y, v: cB.box.get(x, y)}
+		if !cB.box.contains(x, y) {
+			c.bounds = &[4]int{cB.box.minX, cB.box.minY, cB.box.maxX, cB.box.maxY}
+		}
+		cB.journal.add(c)
+	}
+	cB.wroteCell, cB.wrote = true, point{x, y}
+	cB.box.set(x, y, v)
+}
+
+// Tick returns how many ticks the ><> has executed, including any that failed with a RuntimeError.
+func (cB *CodeBox) Tick() int64 {
+	return cB.tick
+}
+
+// StepBack undoes the last tick. It returns false if there's no journal, or the tick isn't in it.
+func (cB *CodeBox) StepBack() bool {
+	j := cB.journal
+	if j == nil || len(j.records) == 0 {
+		return false
+	}
+	rec := j.records[len(j.records)-1]
+	for i := len(j.changes) - 1; i >= rec.start; i-- {
+		cB.undo(&j.changes[i])
+		j.changes[i] = change{}
+	}
+	j.changes = j.changes[:rec.start]
+	j.records = j.records[:len(j.records)-1]
+
+	cB.fX, cB.fY, cB.fDir = rec.fX, rec.fY, rec.fDir
+	cB.wasLeft, cB.escapedHook, cB.deepSea = rec.wasLeft, rec.escapedHook, rec.deepSea
+	cB.stringMode = rec.stringMode
+	cB.p = rec.p
+	cB.tick--
+	return true
+}
+
+// undo reverts c.
+func (cB *CodeBox) undo(c *change) {
+	s := c.s
+	switch c.op {
+	case pushed:
+		s.take()
+	case popped:
+		s.put(c.v)
+	case reversed:
+		s.flipped = !s.flipped
+	case swappedTwo:
+		a, b := s.top(0), s.top(1)
+		*a, *b = *b, *a
+	case swappedThree:
+		a, b, d := s.top(0), s.top(1), s.top(2)
+		*a, *b, *d = *d, *a, *b
+	case prepended:
+		s.takeBottom()
+	case removedFirst:
+		s.putBottom(c.v)
+	case truncated:
+		for _, v := range c.vals {
+			s.put(v)
+		}
+	case appended:
+		for i := 0; i < c.n; i++ {
+			s.take()
+		}
+	case registerSet:
+		s.register, s.filledRegister = c.v, c.n == 1
+	case stacksSet:
+		if cB.prof != nil {
+			kept := make(map[*Stack]bool, len(c.stacks))
+			for _, s := range c.stacks {
+				kept[s] = true
+			}
+			for i, s := range cB.stacks {
+				if !kept[s] {
+					cB.prof.removed(cB.stacks, i)
+				}
+			}
+		}
+		cB.stacks = c.stacks
+	case cellSet:
+		cB.box.restore(c.n, c.y, c.v, c.bounds)
+	}
+}
+
+// JumpTo steps the ><> backward or forward until it has executed tick ticks. Stepping backward returns
+// ErrNotJournaled, without changing anything, if the journal doesn't go back far enough. Stepping forward stops
+// early if the ><> ends or fails, returning ErrEnded or the RuntimeError.
+func (cB *CodeBox) JumpTo(tick int64) error {
+	if tick < cB.tick {
+		if cB.journal == nil || cB.tick-tick > int64(len(cB.journal.records)) {
+			return ErrNotJournaled
+		}
+		for cB.tick > tick {
+			cB.StepBack()
+		}
+		return nil
+	}
+	for cB.tick < tick {
+		end, err := cB.Swim()
+		if err != nil {
+			return err
+		}
+		if end && cB.tick < tick {
+			return ErrEnded
+		}
+	}
+	return nil
+}
+
+// RewindToWrite steps the ><> backward to just before the last tick that wrote to the cell at x, y with "p", so
+// the fish is on the "p" about to execute it. It returns false, without changing anything, if no such write is
+// in the journal.
+func (cB *CodeBox) RewindToWrite(x, y int) bool {
+	j := cB.journal
+	if j == nil {
+		return false
+	}
+	for i := len(j.changes) - 1; i >= 0; i-- {
+		if c := &j.changes[i]; c.op == cellSet && c.n == x && c.y == y {
+			for len(j.changes) > i {
+				cB.StepBack()
+			}
+			return true
+		}
+	}
+	return false
+}
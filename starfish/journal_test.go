@@ -0,0 +1,108 @@
+package starfish
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestStepBack(t *testing.T) {
+	fsys := new(MemFS)
+	fsys.WriteFile("a", []byte("q"))
+	tests := []struct {
+		script   string
+		stack    []float64
+		compMode bool
+	}{
+		{SNAPSHOTSCRIPT, []float64{7}, false},
+		{`12345@$}{r:&&3[r]l&&~~~;`, nil, false},
+		{`123 2[r]r1[]};`, nil, true},
+		{"21C;\n   aR", nil, false},
+		{`a01-0pa01-0p"x"0 01-p;`, nil, false},
+		{`"a"1Fi"xy"2F;`, nil, false},
+		{`v
+>"ab"v
+;    <`, nil, false},
+	}
+	for _, test := range tests {
+		cB := NewCodeBox(test.script, test.stack, test.compMode, WithJournal(100), WithFileSystem(fsys), WithOutput(ioutil.Discard))
+		var snapshots []*Snapshot
+		for end := false; !end && len(snapshots) < 100; {
+			s := cB.Snapshot()
+			s.File = nil // The position of an open file isn't rewound
+			snapshots = append(snapshots, s)
+			var err error
+			if end, err = cB.Swim(); err != nil {
+				t.Fatalf("%q: %v", test.script, err)
+			}
+		}
+		for i := len(snapshots) - 1; i >= 0; i-- {
+			if !cB.StepBack() {
+				t.Fatalf("%q: couldn't step back to tick %d", test.script, i)
+			}
+			s := cB.Snapshot()
+			s.File = nil
+			if !reflect.DeepEqual(s, snapshots[i]) {
+				t.Fatalf("%q: tick %d wasn't restored:\nexpected %+v\ngot      %+v", test.script, i, snapshots[i], s)
+			}
+		}
+		if cB.StepBack() {
+			t.Errorf("%q: stepped back past the start", test.script)
+		}
+	}
+}
+
+func TestJournalLimit(t *testing.T) {
+	cB := NewCodeBox("1+", []float64{0}, false, WithJournal(3))
+	if err := cB.JumpTo(50); err != nil {
+		t.Fatal(err)
+	}
+	if s := cB.Stack(); len(s) != 1 || s[0] != 25 {
+		t.Fatalf("expected [25] after 50 ticks, got %v", s)
+	}
+	if err := cB.JumpTo(46); err != nil {
+		t.Fatal(err)
+	}
+	if s := cB.Stack(); cB.Tick() != 46 || len(s) != 1 || s[0] != 23 {
+		t.Errorf("expected [23] at tick 46, got %v at tick %d", s, cB.Tick())
+	}
+	if err := cB.JumpTo(10); err != ErrNotJournaled {
+		t.Errorf("expected %v, got %v", ErrNotJournaled, err)
+	}
+	if cB.Tick() != 46 {
+		t.Errorf("a failed jump moved to tick %d", cB.Tick())
+	}
+	if err := cB.JumpTo(60); err != nil || cB.Tick() != 60 {
+		t.Errorf("expected to reach tick 60, got %d: %v", cB.Tick(), err)
+	}
+
+	cB = NewCodeBox("1;", nil, false)
+	if err := cB.JumpTo(5); err != ErrEnded || cB.Tick() != 2 {
+		t.Errorf("expected %v at tick 2, got %v at tick %d", ErrEnded, err, cB.Tick())
+	}
+	if cB.StepBack() {
+		t.Errorf("stepped back without a journal")
+	}
+}
+
+func TestRewindToWrite(t *testing.T) {
+	cB := NewCodeBox(`a51p   b51p  c61p ;`, nil, false, WithJournal(100))
+	if err := cB.JumpTo(19); err != nil {
+		t.Fatal(err)
+	}
+	if !cB.RewindToWrite(5, 1) {
+		t.Fatal("didn't find the write to 5,1")
+	}
+	if x, y := cB.Loc(); x != 10 || y != 0 || cB.Tick() != 10 {
+		t.Errorf("expected to be on the second 'p' at tick 10, got %d,%d at tick %d", x, y, cB.Tick())
+	}
+	if v := cB.Cells()[1][5]; v.Float64() != 10 {
+		t.Errorf("expected the cell to hold what the first write put there, got %v", v)
+	}
+	if !cB.RewindToWrite(5, 1) || cB.Tick() != 3 {
+		t.Errorf("expected to rewind to the first write at tick 3, got tick %d", cB.Tick())
+	}
+	if cB.RewindToWrite(5, 1) || cB.RewindToWrite(1, 1) {
+		t.Errorf("found a write that never happened")
+	}
+}
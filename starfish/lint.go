@@ -0,0 +1,172 @@
+package starfish
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Severity is how serious a Diagnostic is.
+type Severity byte
+
+const (
+	Info    Severity = iota // Something the linter couldn't check
+	Warning                 // Probably a mistake
+	Error                   // The ><> fails if it gets there
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	}
+	return fmt.Sprintf("Severity(%d)", byte(s))
+}
+
+// MarshalText encodes s as its name.
+func (s Severity) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+// UnmarshalText decodes a Severity encoded by MarshalText.
+func (s *Severity) UnmarshalText(b []byte) error {
+	for _, sev := range []Severity{Info, Warning, Error} {
+		if sev.String() == string(b) {
+			*s = sev
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown severity %q", b)
+}
+
+// Diagnostic is a problem Lint found in a script.
+type Diagnostic struct {
+	Severity  Severity
+	X, Y      int // The cell the problem is at
+	Line, Col int // X and Y counted from 1, for editors
+	Message   string
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%d:%d: %v: %s", d.Line, d.Col, d.Severity, d.Message)
+}
+
+// LintOptions controls Lint.
+type LintOptions struct {
+	Encoding Encoding // How the script is split into cells, as with WithScriptEncoding
+	Plain    bool     // Report *><> instructions, for scripts meant for plain ><>
+	// CheckStack makes Lint also report what AnalyzeStack finds, starting with Stack values on the stack.
+	CheckStack bool
+	Stack      int
+}
+
+// Lint checks script without running it, following every path the fish can take from the top-left cell. It
+// reports, sorted by position:
+//   - instructions the fish would fail on, as errors;
+//   - string literals that aren't closed before the edge of the codebox, so they wrap around;
+//   - cells the fish can never reach, unless the script jumps somewhere only known at run time;
+//   - *><> instructions, if opts.Plain is set;
+//   - stack underflows and growing loops, if opts.CheckStack is set.
+//
+// Only the script as written is checked: Lint doesn't know what "p" writes, so cells "p" turns into code can
+// be reported as unreachable.
+func Lint(script string, opts LintOptions) []Diagnostic {
+	box := loadScript(script, opts.Encoding)
+	if box.width == 0 {
+		return []Diagnostic{newDiagnostic(Error, 0, 0, "script is empty")}
+	}
+	g := newFlowGraph(box)
+	var diags []Diagnostic
+	seen := make(map[Diagnostic]bool)
+	report := func(d Diagnostic) {
+		if !seen[d] {
+			seen[d] = true
+			diags = append(diags, d)
+		}
+	}
+
+	for _, s := range g.order {
+		op, ok := g.op(s)
+		switch {
+		case s.quote != 0:
+		case !ok:
+			if !s.deep {
+				report(newDiagnostic(Error, s.x, s.y, "unknown instruction "+cellString(box.get(s.x, s.y))))
+			}
+		case opts.Plain && strings.IndexByte(starOnly, op) >= 0:
+			report(newDiagnostic(Warning, s.x, s.y, strconv.QuoteRune(rune(op))+" is a *><> instruction"))
+		case (op == '"' || op == '\'') && !s.deep && g.wraps(s):
+			report(newDiagnostic(Warning, s.x, s.y, "string isn't closed before the edge of the codebox, so it wraps around"))
+		}
+	}
+
+	if len(g.dynamic) > 0 {
+		s := g.dynamic[0]
+		op, _ := g.op(s)
+		report(newDiagnostic(Info, s.x, s.y, fmt.Sprintf("can't check for unreachable code, as %q jumps to a target on the stack", op)))
+	} else {
+		reached := g.reached()
+		for y := box.minY; y <= box.maxY; y++ {
+			for x := box.minX; x <= box.maxX; x++ {
+				n := 0
+				for x+n <= box.maxX && !reached[point{x + n, y}] && !empty(box.get(x+n, y)) {
+					n++
+				}
+				switch {
+				case n == 1:
+					report(newDiagnostic(Warning, x, y, "unreachable cell"))
+				case n > 1:
+					report(newDiagnostic(Warning, x, y, fmt.Sprintf("%d unreachable cells", n)))
+				}
+				x += n
+			}
+		}
+	}
+
+	if opts.CheckStack {
+		for _, d := range AnalyzeStack(script, opts).Diagnostics {
+			report(d)
+		}
+	}
+	sortDiagnostics(diags)
+	return diags
+}
+
+// sortDiagnostics sorts diags by position, keeping diagnostics of the same cell in order.
+func sortDiagnostics(diags []Diagnostic) {
+	sort.SliceStable(diags, func(i, j int) bool {
+		a, b := diags[i], diags[j]
+		return a.Y < b.Y || (a.Y == b.Y && a.X < b.X)
+	})
+}
+
+func newDiagnostic(sev Severity, x, y int, msg string) Diagnostic {
+	return Diagnostic{Severity: sev, X: x, Y: y, Line: y + 1, Col: x + 1, Message: msg}
+}
+
+// empty returns true if v is an empty cell or a space.
+func empty(v Value) bool {
+	op, ok := opcode(v)
+	return ok && (op == 0 || op == ' ')
+}
+
+// wraps returns true if the string the fish opens at s isn't closed before it reaches the edge of the codebox.
+func (g *flowGraph) wraps(s fishState) bool {
+	op, _ := g.op(s)
+	for {
+		n := g.move(s)
+		if (s.dir == Right && n.x < s.x) || (s.dir == Left && n.x > s.x) ||
+			(s.dir == Down && n.y < s.y) || (s.dir == Up && n.y > s.y) || n == s {
+			return true
+		}
+		if c, ok := g.op(n); ok && c == op {
+			return false
+		}
+		s = n
+	}
+}
@@ -0,0 +1,53 @@
+package starfish
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+var lintTests = []struct {
+	script string
+	plain  bool
+	diags  []string
+}{
+	{"1n;", false, nil},
+	{"1z;", false, []string{"1:2: error: unknown instruction 'z'", "1:3: warning: unreachable cell"}},
+	{"1n;\nabc", false, []string{"2:1: warning: 3 unreachable cells"}},
+	{"v\n>1n;", false, nil},
+	{"!;1n;", false, []string{"1:2: warning: unreachable cell"}},
+	{"i?;1n;", false, nil},
+	{"x;\n;;", false, []string{"2:2: warning: unreachable cell"}},
+	{`"ab;`, false, []string{"1:1: warning: string isn't closed before the edge of the codebox, so it wraps around"}},
+	{` "a" ;`, false, nil},
+	{"v\n'\na\n;\n'", false, nil},
+	{"h;", false, nil},
+	{"h;", true, []string{"1:1: warning: 'h' is a *><> instruction"}},
+	{"uz O;", false, nil},
+	{"00.\nzzz", false, []string{"1:3: info: can't check for unreachable code, as '.' jumps to a target on the stack"}},
+	{"", false, []string{"1:1: error: script is empty"}},
+}
+
+func TestLint(t *testing.T) {
+	for _, test := range lintTests {
+		var got []string
+		for _, d := range Lint(test.script, LintOptions{Plain: test.plain}) {
+			got = append(got, d.String())
+		}
+		if !reflect.DeepEqual(got, test.diags) {
+			t.Errorf("%q: got %q, expected %q", test.script, got, test.diags)
+		}
+	}
+}
+
+func TestDiagnosticJSON(t *testing.T) {
+	d := Lint("z", LintOptions{})[0]
+	b, err := json.Marshal(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var d2 Diagnostic
+	if err := json.Unmarshal(b, &d2); err != nil || d2 != d {
+		t.Errorf("%s decoded as %+v, %v", b, d2, err)
+	}
+}
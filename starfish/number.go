@@ -0,0 +1,404 @@
+package starfish
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// ErrDivisionByZero is returned by a Numeric when asked to divide by zero.
+var ErrDivisionByZero = errors.New("division by zero")
+
+type valueKind byte
+
+const (
+	intValue valueKind = iota
+	floatValue
+	ratValue
+)
+
+// Value is a number on a stack or in a codebox cell. A Value holds an int64, a float64, or an arbitrary-precision
+// rational, depending on the Numeric backend that made it. The zero Value is the integer 0.
+type Value struct {
+	kind valueKind
+	n    uint64   // An int64, or the bits of a float64
+	r    *big.Rat // Never modified once it's in a Value
+}
+
+// Int returns i as a Value.
+func Int(i int64) Value {
+	return Value{kind: intValue, n: uint64(i)}
+}
+
+// Float returns f as a Value.
+func Float(f float64) Value {
+	return Value{kind: floatValue, n: math.Float64bits(f)}
+}
+
+// Rat returns a copy of r as a Value. Integers that fit in an int64 are stored as one.
+func Rat(r *big.Rat) Value {
+	if r.IsInt() && r.Num().IsInt64() {
+		return Int(r.Num().Int64())
+	}
+	return Value{kind: ratValue, r: new(big.Rat).Set(r)}
+}
+
+// Float64 returns the nearest float64 to v.
+func (v Value) Float64() float64 {
+	switch v.kind {
+	case floatValue:
+		return math.Float64frombits(v.n)
+	case ratValue:
+		f, _ := v.r.Float64()
+		return f
+	}
+	return float64(int64(v.n))
+}
+
+// Int64 returns v with any fraction dropped. Values too large for an int64 are clamped, and NaN is 0.
+func (v Value) Int64() int64 {
+	switch v.kind {
+	case floatValue:
+		f := math.Float64frombits(v.n)
+		switch {
+		case f != f:
+			return 0
+		case f >= math.MaxInt64:
+			return math.MaxInt64
+		case f <= math.MinInt64:
+			return math.MinInt64
+		}
+		return int64(f)
+	case ratValue:
+		i := new(big.Int).Quo(v.r.Num(), v.r.Denom())
+		if i.IsInt64() {
+			return i.Int64()
+		} else if i.Sign() > 0 {
+			return math.MaxInt64
+		}
+		return math.MinInt64
+	}
+	return int64(v.n)
+}
+
+// Rat returns v as a new big.Rat, or nil if v is an infinity or NaN.
+func (v Value) Rat() *big.Rat {
+	switch v.kind {
+	case floatValue:
+		f := math.Float64frombits(v.n)
+		if math.IsInf(f, 0) || f != f {
+			return nil
+		}
+		return new(big.Rat).SetFloat64(f)
+	case ratValue:
+		return new(big.Rat).Set(v.r)
+	}
+	return new(big.Rat).SetInt64(int64(v.n))
+}
+
+// IsZero returns true if v is 0.
+func (v Value) IsZero() bool {
+	switch v.kind {
+	case floatValue:
+		return math.Float64frombits(v.n) == 0
+	case ratValue:
+		return v.r.Sign() == 0
+	}
+	return v.n == 0
+}
+
+// exactInt returns v as an int64, if v is an integer that fits in one.
+func (v Value) exactInt() (int64, bool) {
+	switch v.kind {
+	case floatValue:
+		f := math.Float64frombits(v.n)
+		if f == math.Trunc(f) && f >= math.MinInt64 && f < math.MaxInt64 {
+			return int64(f), true
+		}
+		return 0, false
+	case ratValue:
+		if v.r.IsInt() && v.r.Num().IsInt64() {
+			return v.r.Num().Int64(), true
+		}
+		return 0, false
+	}
+	return int64(v.n), true
+}
+
+// int returns v as an int with any fraction dropped, as used for coordinates and counts.
+func (v Value) int() int {
+	return int(v.Int64())
+}
+
+// String formats v the way "n" outputs it.
+func (v Value) String() string {
+	switch v.kind {
+	case floatValue:
+		return strconv.FormatFloat(math.Float64frombits(v.n), 'g', -1, 64)
+	case ratValue:
+		if v.r.IsInt() {
+			return v.r.Num().String()
+		}
+		return new(big.Float).SetPrec(64).SetRat(v.r).Text('g', 17)
+	}
+	return strconv.FormatInt(int64(v.n), 10)
+}
+
+// MarshalJSON encodes v so that UnmarshalJSON restores it exactly, kind included. Integers are JSON numbers,
+// floats are JSON numbers with a fraction or exponent, and rationals, infinities and NaN are strings.
+func (v Value) MarshalJSON() ([]byte, error) {
+	switch v.kind {
+	case floatValue:
+		f := math.Float64frombits(v.n)
+		if math.IsInf(f, 0) || f != f {
+			return []byte(strconv.Quote(strconv.FormatFloat(f, 'g', -1, 64))), nil
+		}
+		s := strconv.FormatFloat(f, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".e") {
+			s += ".0"
+		}
+		return []byte(s), nil
+	case ratValue:
+		return []byte(strconv.Quote(v.r.RatString())), nil
+	}
+	return []byte(strconv.FormatInt(int64(v.n), 10)), nil
+}
+
+// UnmarshalJSON decodes a Value encoded by MarshalJSON.
+func (v *Value) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if strings.HasPrefix(s, "\"") {
+		s, err := strconv.Unquote(s)
+		if err != nil {
+			return err
+		}
+		switch s {
+		case "+Inf", "-Inf", "NaN":
+			f, _ := strconv.ParseFloat(s, 64)
+			*v = Float(f)
+			return nil
+		}
+		r, ok := new(big.Rat).SetString(s)
+		if !ok {
+			return fmt.Errorf("invalid value %q", s)
+		}
+		*v = Rat(r)
+		return nil
+	}
+	if strings.ContainsAny(s, ".eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		*v = Float(f)
+		return nil
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return err
+	}
+	*v = Int(i)
+	return nil
+}
+
+// compare returns -1, 0 or 1 as a is less than, equal to, or greater than b. The bool is false if a or b is NaN.
+func compare(a, b Value) (int, bool) {
+	switch {
+	case a.kind == intValue && b.kind == intValue:
+		x, y := int64(a.n), int64(b.n)
+		if x < y {
+			return -1, true
+		} else if x > y {
+			return 1, true
+		}
+		return 0, true
+	case a.kind == floatValue || b.kind == floatValue:
+		x, y := a.Float64(), b.Float64()
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		case x == y:
+			return 0, true
+		}
+		return 0, false
+	}
+	return a.Rat().Cmp(b.Rat()), true
+}
+
+// Numeric is the arithmetic a CodeBox uses for its values. Operands may be Values of any kind, such as those
+// read from an empty cell, and should be converted as needed.
+type Numeric interface {
+	// FromInt returns i as a Value. It's used for literals, characters, and the results of "l" and "i".
+	FromInt(i int64) Value
+	// Convert returns v as a Value of this backend. It's used for the initial stack.
+	Convert(v Value) Value
+	Add(a, b Value) Value
+	Sub(a, b Value) Value
+	Mul(a, b Value) Value
+	// Div returns a / b, or ErrDivisionByZero.
+	Div(a, b Value) (Value, error)
+	// Mod returns a modulo b, or ErrDivisionByZero.
+	Mod(a, b Value) (Value, error)
+}
+
+// WithNumeric sets the arithmetic a CodeBox uses. By default it uses Float64Numeric.
+func WithNumeric(n Numeric) Option {
+	return func(cB *CodeBox) {
+		cB.num = n
+	}
+}
+
+// WithStack replaces the initial stack given to NewCodeBox with values, converted by the CodeBox's Numeric.
+// Unlike a []float64, values can hold numbers of any size.
+func WithStack(values []Value) Option {
+	return func(cB *CodeBox) {
+		cB.stacks = []*Stack{NewStack(values)}
+	}
+}
+
+// Float64Numeric stores every value as a float64, as ><> traditionally does. Integers above 2^53 lose precision.
+type Float64Numeric struct{}
+
+func (Float64Numeric) FromInt(i int64) Value { return Float(float64(i)) }
+func (Float64Numeric) Convert(v Value) Value { return Float(v.Float64()) }
+func (Float64Numeric) Add(a, b Value) Value  { return Float(a.Float64() + b.Float64()) }
+func (Float64Numeric) Sub(a, b Value) Value  { return Float(a.Float64() - b.Float64()) }
+func (Float64Numeric) Mul(a, b Value) Value  { return Float(a.Float64() * b.Float64()) }
+
+// Div divides a by b.
+func (Float64Numeric) Div(a, b Value) (Value, error) {
+	y := b.Float64()
+	if y == 0 {
+		return Value{}, ErrDivisionByZero
+	}
+	return Float(a.Float64() / y), nil
+}
+
+// Mod returns a - b*floor(a/b), which has the sign of b. Fractions are kept, so 5.5 modulo 2 is 1.5.
+func (Float64Numeric) Mod(a, b Value) (Value, error) {
+	x, y := a.Float64(), b.Float64()
+	if y == 0 {
+		return Value{}, ErrDivisionByZero
+	}
+	m := math.Mod(x, y)
+	if m != 0 && (m < 0) != (y < 0) {
+		m += y
+	}
+	return Float(m), nil
+}
+
+// Int64Numeric stores every value as an int64, for fast integer-only programs. Fractions are dropped when
+// converting, "," rounds down, and overflow wraps around.
+type Int64Numeric struct{}
+
+func (Int64Numeric) FromInt(i int64) Value { return Int(i) }
+func (Int64Numeric) Convert(v Value) Value { return Int(v.Int64()) }
+func (Int64Numeric) Add(a, b Value) Value  { return Int(a.Int64() + b.Int64()) }
+func (Int64Numeric) Sub(a, b Value) Value  { return Int(a.Int64() - b.Int64()) }
+func (Int64Numeric) Mul(a, b Value) Value  { return Int(a.Int64() * b.Int64()) }
+
+// Div returns floor(a/b).
+func (Int64Numeric) Div(a, b Value) (Value, error) {
+	x, y := a.Int64(), b.Int64()
+	if y == 0 {
+		return Value{}, ErrDivisionByZero
+	}
+	q := x / y
+	if x%y != 0 && (x < 0) != (y < 0) {
+		q--
+	}
+	return Int(q), nil
+}
+
+// Mod returns a - b*floor(a/b), which has the sign of b.
+func (Int64Numeric) Mod(a, b Value) (Value, error) {
+	x, y := a.Int64(), b.Int64()
+	if y == 0 {
+		return Value{}, ErrDivisionByZero
+	}
+	m := x % y
+	if m != 0 && (m < 0) != (y < 0) {
+		m += y
+	}
+	return Int(m), nil
+}
+
+// RationalNumeric stores values exactly, as integers of any size and fractions of them. Integers that fit in an
+// int64 are stored as one, so integer-only programs stay fairly fast.
+type RationalNumeric struct{}
+
+func (RationalNumeric) FromInt(i int64) Value { return Int(i) }
+
+// Convert returns v as an exact rational. Infinities and NaN become 0.
+func (RationalNumeric) Convert(v Value) Value {
+	if v.kind != floatValue {
+		return v
+	}
+	if r := v.Rat(); r != nil {
+		return Rat(r)
+	}
+	return Value{}
+}
+
+func (n RationalNumeric) Add(a, b Value) Value {
+	if a.kind == intValue && b.kind == intValue {
+		x, y := int64(a.n), int64(b.n)
+		if s := x + y; (s > x) == (y > 0) {
+			return Int(s)
+		}
+	}
+	return n.rat(a, b, (*big.Rat).Add)
+}
+
+func (n RationalNumeric) Sub(a, b Value) Value {
+	if a.kind == intValue && b.kind == intValue {
+		x, y := int64(a.n), int64(b.n)
+		if s := x - y; (s < x) == (y > 0) {
+			return Int(s)
+		}
+	}
+	return n.rat(a, b, (*big.Rat).Sub)
+}
+
+func (n RationalNumeric) Mul(a, b Value) Value {
+	if a.kind == intValue && b.kind == intValue {
+		x, y := int64(a.n), int64(b.n)
+		if x == 0 || y == 0 {
+			return Int(0)
+		}
+		if p := x * y; p/y == x && !(x == -1 && y == math.MinInt64) && !(y == -1 && x == math.MinInt64) {
+			return Int(p)
+		}
+	}
+	return n.rat(a, b, (*big.Rat).Mul)
+}
+
+// Div divides a by b exactly.
+func (n RationalNumeric) Div(a, b Value) (Value, error) {
+	if b.IsZero() {
+		return Value{}, ErrDivisionByZero
+	}
+	return n.rat(a, b, (*big.Rat).Quo), nil
+}
+
+// Mod returns a - b*floor(a/b), which has the sign of b.
+func (n RationalNumeric) Mod(a, b Value) (Value, error) {
+	if b.IsZero() {
+		return Value{}, ErrDivisionByZero
+	}
+	x, y := n.Convert(a).Rat(), n.Convert(b).Rat()
+	q := new(big.Rat).Quo(x, y)
+	fl := new(big.Int).Div(q.Num(), q.Denom()) // Euclidean division of the numerator by the positive denominator floors
+	return Rat(x.Sub(x, new(big.Rat).Mul(y, new(big.Rat).SetInt(fl)))), nil
+}
+
+// rat applies op to a and b as rationals.
+func (n RationalNumeric) rat(a, b Value, op func(z, x, y *big.Rat) *big.Rat) Value {
+	x, y := n.Convert(a).Rat(), n.Convert(b).Rat()
+	return Rat(op(x, x, y))
+}
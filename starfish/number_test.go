@@ -0,0 +1,69 @@
+package starfish
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"testing"
+)
+
+// runNumeric runs script with num and initial stack values, and returns its output.
+func runNumeric(script string, num Numeric, values ...Value) string {
+	var out bytes.Buffer
+	runscript(script, nil, false, WithNumeric(num), WithStack(values), WithOutput(&out))
+	return out.String()
+}
+
+func TestNumericBackends(t *testing.T) {
+	big2to100, _ := new(big.Rat).SetString("1267650600228229401496703205376")
+	tests := []struct {
+		script string
+		num    Numeric
+		values []Value
+		out    string
+	}{
+		{"1+n;", Float64Numeric{}, []Value{Int(1<<62 + 1)}, "4.611686018427388e+18"},
+		{"1+n;", Int64Numeric{}, []Value{Int(1<<62 + 1)}, "4611686018427387906"},
+		{"1+n;", RationalNumeric{}, []Value{Int(1<<62 + 1)}, "4611686018427387906"},
+		{"23,n;", Float64Numeric{}, nil, "0.6666666666666666"},
+		{"73,n;", Int64Numeric{}, nil, "2"},
+		{"07-2,n;", Int64Numeric{}, nil, "-4"},
+		{"07-3%n;", Int64Numeric{}, nil, "2"},
+		{"13,3*n;", RationalNumeric{}, nil, "1"},
+		{"23,n;", RationalNumeric{}, nil, "0.66666666666666667"},
+		{":*n;", RationalNumeric{}, []Value{Rat(big2to100)}, "1606938044258990275541962092341162602522202993782792835301376"},
+		{":*:*n;", RationalNumeric{}, []Value{Int(1 << 40)}, "1461501637330902918203684832716283019655932542976"},
+		{"2%n;", RationalNumeric{}, []Value{Rat(big.NewRat(11, 2))}, "1.5"},
+		{"07-3%n;", RationalNumeric{}, nil, "2"},
+		{"25,2*1=n;", Int64Numeric{}, nil, "0"},
+		{"25,2*1=n;", RationalNumeric{}, nil, "0"},
+		{"=n;", Float64Numeric{}, []Value{Float(math.NaN()), Float(math.NaN())}, "0"}, // NaN doesn't equal itself
+	}
+	for _, test := range tests {
+		if out := runNumeric(test.script, test.num, test.values...); out != test.out {
+			t.Errorf("%T %q: expected %q, got %q", test.num, test.script, test.out, out)
+		}
+	}
+}
+
+func TestDivisionByZero(t *testing.T) {
+	for _, num := range []Numeric{Float64Numeric{}, Int64Numeric{}, RationalNumeric{}} {
+		cB := NewCodeBox("10,", nil, false, WithNumeric(num))
+		var err error
+		for i := 0; err == nil && i < 3; i++ {
+			_, err = cB.Swim()
+		}
+		if rErr, ok := err.(*RuntimeError); !ok || rErr.Kind != DivisionByZero {
+			t.Errorf("%T: expected a division by zero, got %v", num, err)
+		}
+	}
+}
+
+func TestRatValues(t *testing.T) {
+	if v := Rat(big.NewRat(6, 3)); v != Int(2) {
+		t.Errorf("expected 6/3 to be stored as the integer 2, got %#v", v)
+	}
+	if v := Rat(big.NewRat(-7, 2)); v.Int64() != -3 || v.Float64() != -3.5 || v.String() != "-3.5" {
+		t.Errorf("unexpected conversions of -7/2: %v %v %v", v.Int64(), v.Float64(), v)
+	}
+}
@@ -0,0 +1,125 @@
+package starfish
+
+import (
+	"sort"
+	"time"
+)
+
+// Profile reports how often each cell and instruction of a ><> executed, and how deep its stacks got. It's
+// collected by CodeBox.Profile, and can be encoded with encoding/json.
+type Profile struct {
+	Ticks        int64                // Ticks executed, including one that failed
+	Time         time.Duration        // Time spent in those ticks
+	Cells        []ProfileCell        // Every cell that executed, sorted by row and then column
+	Instructions map[string]ProfileOp // Keyed by instruction; empty cells are counted as " "
+	Pushes       ProfileOp            // Characters pushed in string mode
+	// MaxDepth is the most values each stack held, from the bottom stack up. A stack that "]" or "R" removed
+	// counts toward the stack at the index it was removed from.
+	MaxDepth []int
+}
+
+// ProfileCell is the number of times the cell at X, Y executed.
+type ProfileCell struct {
+	X, Y  int
+	Count int64
+}
+
+// ProfileOp is how many times an instruction executed, and the time it took.
+type ProfileOp struct {
+	Count int64
+	Time  time.Duration
+}
+
+// profiler collects a Profile.
+type profiler struct {
+	ticks    int64
+	time     time.Duration
+	cells    map[point]int64
+	ops      [256]ProfileOp
+	pushes   ProfileOp
+	maxDepth []int // The most values held by the stacks removed from each index
+}
+
+// WithProfile makes the CodeBox profile the ticks it executes, for CodeBox.Profile. Profiling slows every tick
+// down, mostly by timing it. Ticks undone with StepBack stay counted, and a clone starts a profile of its own.
+func WithProfile() Option {
+	return func(cB *CodeBox) {
+		cB.prof = &profiler{cells: make(map[point]int64)}
+	}
+}
+
+// Profile returns the profile of every tick the CodeBox has executed, or nil if it wasn't made with WithProfile.
+// The Profile shares nothing with the CodeBox.
+func (cB *CodeBox) Profile() *Profile {
+	p := cB.prof
+	if p == nil {
+		return nil
+	}
+	prof := &Profile{
+		Ticks:        p.ticks,
+		Time:         p.time,
+		Cells:        make([]ProfileCell, 0, len(p.cells)),
+		Instructions: make(map[string]ProfileOp),
+		Pushes:       p.pushes,
+		MaxDepth:     append([]int{}, p.maxDepth...),
+	}
+	for i, s := range cB.stacks {
+		if i == len(prof.MaxDepth) {
+			prof.MaxDepth = append(prof.MaxDepth, 0)
+		}
+		if s.max > prof.MaxDepth[i] {
+			prof.MaxDepth[i] = s.max
+		}
+	}
+	for c, n := range p.cells {
+		prof.Cells = append(prof.Cells, ProfileCell{c.x, c.y, n})
+	}
+	sort.Slice(prof.Cells, func(i, j int) bool {
+		a, b := prof.Cells[i], prof.Cells[j]
+		return a.Y < b.Y || (a.Y == b.Y && a.X < b.X)
+	})
+	for op, o := range p.ops {
+		if o.Count > 0 {
+			if op == 0 {
+				op = ' '
+			}
+			o.Count += prof.Instructions[string(rune(op))].Count
+			o.Time += prof.Instructions[string(rune(op))].Time
+			prof.Instructions[string(rune(op))] = o
+		}
+	}
+	return prof
+}
+
+// count records a tick that executed the cell at x, y, starting at start. op is the instruction it executed,
+// if executed is set, and otherwise the cell was pushed in string mode or ignored in deep sea mode. Ignored
+// cells only count as a tick, as nothing in them ran.
+func (p *profiler) count(cB *CodeBox, x, y int, op byte, executed bool, start time.Time) {
+	d := time.Since(start)
+	p.ticks++
+	p.time += d
+	if executed || !cB.deepSea {
+		p.cells[point{x, y}]++
+	}
+	if executed {
+		p.ops[op].Count++
+		p.ops[op].Time += d
+	} else if cB.stringMode != 0 {
+		p.pushes.Count++
+		p.pushes.Time += d
+	}
+}
+
+// removed records the most values stacks[i] held, as "]", "R" or StepBack removes it from the CodeBox's stacks.
+// It does nothing if p is nil.
+func (p *profiler) removed(stacks []*Stack, i int) {
+	if p == nil {
+		return
+	}
+	for len(p.maxDepth) <= i {
+		p.maxDepth = append(p.maxDepth, 0)
+	}
+	if n := stacks[i].max; n > p.maxDepth[i] {
+		p.maxDepth[i] = n
+	}
+}
@@ -0,0 +1,73 @@
+package starfish
+
+import (
+	"context"
+	"testing"
+)
+
+func TestProfile(t *testing.T) {
+	cB := NewCodeBox("1-:0=?;", []float64{3}, false, WithProfile())
+	if _, err := cB.Run(context.Background(), RunOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	p := cB.Profile()
+	if p.Ticks != 19 {
+		t.Errorf("%d ticks, expected 19", p.Ticks)
+	}
+	if len(p.Cells) != 7 || p.Cells[0] != (ProfileCell{0, 0, 3}) || p.Cells[6] != (ProfileCell{6, 0, 1}) {
+		t.Errorf("cells: %v", p.Cells)
+	}
+	if p.Instructions["-"].Count != 3 || p.Instructions[";"].Count != 1 || len(p.Instructions) != 7 {
+		t.Errorf("instructions: %v", p.Instructions)
+	}
+	if len(p.MaxDepth) != 1 || p.MaxDepth[0] != 3 {
+		t.Errorf("max depth: %v", p.MaxDepth)
+	}
+}
+
+func TestProfileStrings(t *testing.T) {
+	cB := NewCodeBox(`"ab"1[ 1];`, nil, false, WithProfile())
+	if _, err := cB.Run(context.Background(), RunOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	p := cB.Profile()
+	if p.Pushes.Count != 2 || p.Instructions[`"`].Count != 2 || p.Instructions[" "].Count != 1 {
+		t.Errorf("instructions: %v, pushes: %v", p.Instructions, p.Pushes)
+	}
+	if len(p.MaxDepth) != 2 || p.MaxDepth[0] != 3 || p.MaxDepth[1] != 2 {
+		t.Errorf("max depth: %v", p.MaxDepth)
+	}
+}
+
+// TestProfileCallDepth checks the most values each stack held is reported for that stack, even once "C" has
+// moved it up to make room for the stack it returns to.
+func TestProfileCallDepth(t *testing.T) {
+	cB := NewCodeBox("31C\n;;;;;", []float64{1, 2, 3, 4, 5}, false, WithProfile())
+	if _, err := cB.Run(context.Background(), RunOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if p := cB.Profile(); len(p.MaxDepth) != 2 || p.MaxDepth[0] != 2 || p.MaxDepth[1] != 7 {
+		t.Errorf("max depth: %v", p.MaxDepth)
+	}
+}
+
+// TestProfileDeepSea checks cells skipped in deep sea mode aren't counted as executed.
+func TestProfileDeepSea(t *testing.T) {
+	cB := NewCodeBox("u1n O;", nil, false, WithProfile())
+	if _, err := cB.Run(context.Background(), RunOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	p := cB.Profile()
+	if p.Ticks != 6 || len(p.Cells) != 4 {
+		t.Errorf("%d ticks, cells: %v", p.Ticks, p.Cells)
+	}
+	if _, ok := p.Instructions["n"]; ok || p.Instructions["O"].Count != 1 || len(p.Instructions) != 4 {
+		t.Errorf("instructions: %v", p.Instructions)
+	}
+}
+
+func TestProfileDisabled(t *testing.T) {
+	if NewCodeBox(";", nil, false).Profile() != nil {
+		t.Error("profile without WithProfile")
+	}
+}
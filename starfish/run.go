@@ -0,0 +1,123 @@
+package starfish
+
+import (
+	"context"
+	"time"
+)
+
+// StopReason describes why CodeBox.Run returned.
+type StopReason byte
+
+const (
+	Ended     StopReason = iota // The ><> executed ";"
+	Cancelled                   // The context was cancelled or its deadline passed
+	TickLimit                   // RunOptions.MaxTicks ticks were executed
+	Failed                      // The ><> hit a RuntimeError
+	Paused                      // A Breakpoint was hit
+)
+
+func (r StopReason) String() string {
+	switch r {
+	case Ended:
+		return "ended"
+	case Cancelled:
+		return "cancelled"
+	case TickLimit:
+		return "tick limit reached"
+	case Failed:
+		return "failed"
+	case Paused:
+		return "paused"
+	}
+	return "unknown"
+}
+
+// RunOptions controls CodeBox.Run. The zero value runs until the ><> ends, fails, or the context is done.
+type RunOptions struct {
+	MaxTicks int64          // Stop after this many ticks, if greater than 0
+	Delay    time.Duration  // Time to sleep between ticks, on the CodeBox's Clock
+	Tick     func(*CodeBox) // Called after every successful tick, if set
+}
+
+// RunResult reports how many ticks CodeBox.Run executed and why it stopped.
+type RunResult struct {
+	Ticks      int64
+	Reason     StopReason
+	Breakpoint *Breakpoint // The breakpoint that was hit, if Reason is Paused
+}
+
+// Run calls Swim until the ><> executes ";", fails, hits a Breakpoint, ctx is done, or opts.MaxTicks is reached.
+// Calling Run again after it pauses continues from the breakpoint. A RuntimeError is
+// returned as is, and ctx.Err() is returned if ctx is done. "S", and "i" in blocking mode, are cut short when ctx
+// is done.
+//
+// When nothing needs to see the fish between ticks, because the CodeBox has no journal, trace, profile or
+// breakpoints and opts has no Tick or Delay, Run races through straight runs of instructions rather than calling
+// Swim for each tick. The result is the same.
+func (cB *CodeBox) Run(ctx context.Context, opts RunOptions) (res RunResult, err error) {
+	cB.ctx = ctx
+	defer func() {
+		cB.ctx = nil
+	}()
+	done := ctx.Done()
+	// Nothing needs to see the fish between ticks, so it can race
+	fast := cB.journal == nil && cB.trace == nil && cB.prof == nil && len(cB.breakpoints) == 0 && opts.Tick == nil &&
+		opts.Delay <= 0
+
+	for {
+		if opts.MaxTicks > 0 && res.Ticks >= opts.MaxTicks {
+			res.Reason = TickLimit
+			return res, cB.Flush()
+		}
+		select {
+		case <-done:
+			res.Reason = Cancelled
+			cB.Flush()
+			return res, ctx.Err()
+		default:
+		}
+
+		if len(cB.breakpoints) > 0 {
+			if b := cB.breakBefore(); b != nil {
+				res.Reason, res.Breakpoint = Paused, b
+				return res, cB.Flush()
+			}
+		}
+
+		var end bool
+		if fast {
+			var ticks int64
+			ticks, end, err = cB.race(done, opts.MaxTicks-res.Ticks)
+			res.Ticks += ticks
+		} else {
+			end, err = cB.Swim()
+		}
+		if err != nil && err == ctx.Err() {
+			res.Reason = Cancelled
+			return res, err
+		}
+		if err != nil {
+			res.Reason = Failed
+			return res, err
+		}
+		if !fast {
+			res.Ticks++
+		}
+		if opts.Tick != nil {
+			opts.Tick(cB)
+		}
+		if end {
+			res.Reason = Ended
+			return res, nil
+		}
+		if len(cB.breakpoints) > 0 {
+			if b := cB.watchAfter(); b != nil {
+				res.Reason, res.Breakpoint = Paused, b
+				return res, cB.Flush()
+			}
+		}
+		if opts.Delay > 0 {
+			cB.clock.Sleep(ctx, opts.Delay)
+		}
+	}
+}
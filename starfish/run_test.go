@@ -0,0 +1,78 @@
+package starfish
+
+import (
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestRunEnded(t *testing.T) {
+	cB := NewCodeBox("12+;", nil, false)
+	res, err := cB.Run(context.Background(), RunOptions{})
+	if err != nil || res.Reason != Ended || res.Ticks != 4 {
+		t.Fatal(res, err)
+	}
+}
+
+func TestRunTickLimit(t *testing.T) {
+	cB := NewCodeBox(">", nil, false)
+	res, err := cB.Run(context.Background(), RunOptions{MaxTicks: 100})
+	if err != nil || res.Reason != TickLimit || res.Ticks != 100 {
+		t.Fatal(res, err)
+	}
+}
+
+func TestRunFailed(t *testing.T) {
+	cB := NewCodeBox("1~~", nil, false)
+	res, err := cB.Run(context.Background(), RunOptions{})
+	if _, ok := err.(*RuntimeError); !ok || res.Reason != Failed || res.Ticks != 2 {
+		t.Fatal(res, err)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	cB := NewCodeBox(">", nil, false)
+	res, err := cB.Run(ctx, RunOptions{})
+	if err != context.DeadlineExceeded || res.Reason != Cancelled {
+		t.Fatal(res, err)
+	}
+}
+
+func TestRunCancelsSleep(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	cB := NewCodeBox("aa*S;", nil, false, WithOutput(ioutil.Discard)) // Sleeps for 10 seconds
+	start := time.Now()
+	res, err := cB.Run(ctx, RunOptions{})
+	if err != context.DeadlineExceeded || res.Reason != Cancelled || res.Ticks != 4 {
+		t.Fatal(res, err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("S wasn't cut short")
+	}
+}
+
+func TestRunDelayUsesClock(t *testing.T) {
+	start := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	cB := NewCodeBox("11+;", nil, false, WithClock(clock))
+	res, err := cB.Run(context.Background(), RunOptions{Delay: time.Hour})
+	if err != nil || res.Reason != Ended {
+		t.Fatal(res, err)
+	}
+	if d := clock.Now().Sub(start); d != 3*time.Hour {
+		t.Fatal("the clock moved", d)
+	}
+}
+
+func TestRunTick(t *testing.T) {
+	var ticks int64
+	cB := NewCodeBox("11+;", nil, false)
+	res, _ := cB.Run(context.Background(), RunOptions{Tick: func(*CodeBox) { ticks++ }})
+	if ticks != res.Ticks {
+		t.Fail()
+	}
+}
@@ -0,0 +1,214 @@
+package starfish
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// SnapshotVersion is the version of Snapshot this package writes. Restore rejects snapshots of other versions.
+const SnapshotVersion = 1
+
+// maxSnapshotScript is the most cells Restore accepts in the script a Snapshot was loaded from. Restore allocates
+// them all up front, so the limit keeps a crafted snapshot from making it allocate more than a few hundred
+// megabytes. Real ><> scripts are far smaller.
+const maxSnapshotScript = 1 << 22
+
+// ErrInvalidSnapshot is returned by CodeBox.Restore for snapshots it can't restore.
+var ErrInvalidSnapshot = errors.New("invalid snapshot")
+
+// Snapshot is the complete state of a running ><>: the codebox, the fish, and its stacks. It doesn't include
+// how the CodeBox was configured, such as its input, output, FileSystem, Clock or Numeric, or any input that
+// has been received but not yet read by "i". Snapshots can be encoded with encoding/json.
+type Snapshot struct {
+	Version     int
+	Tick        int64 // How many ticks the ><> has executed
+	X, Y        int
+	Dir         Direction
+	WasLeft     bool
+	EscapedHook bool
+	// Width and Height are the size of the script the codebox was loaded from, and the bounds hold every
+	// cell that has been written since.
+	Width, Height          int
+	MinX, MinY, MaxX, MaxY int
+	Cells                  []SnapshotCell // Every cell that isn't empty
+	Stacks                 []SnapshotStack
+	P                      int // The index of the current stack
+	StringMode             byte
+	CompMode               bool
+	DeepSea                bool
+	// File is the file "F" has open, if any. Only its name and how much of it has been read are saved; it's
+	// reopened in the CodeBox's FileSystem when the snapshot is restored.
+	File *SnapshotFile `json:",omitempty"`
+}
+
+// SnapshotCell is a cell of the codebox in a Snapshot.
+type SnapshotCell struct {
+	X, Y  int
+	Value Value
+}
+
+// SnapshotStack is a stack in a Snapshot.
+type SnapshotStack struct {
+	Values   []Value
+	Register *Value `json:",omitempty"` // Nil if the register is empty
+}
+
+// SnapshotFile is the file "F" has open in a Snapshot.
+type SnapshotFile struct {
+	Name   string
+	Offset int64 // How many bytes "i" has read
+}
+
+// Snapshot returns a copy of the state of cB. It shares nothing with cB.
+func (cB *CodeBox) Snapshot() *Snapshot {
+	s := &Snapshot{
+		Version:     SnapshotVersion,
+		Tick:        cB.tick,
+		X:           cB.fX,
+		Y:           cB.fY,
+		Dir:         cB.fDir,
+		WasLeft:     cB.wasLeft,
+		EscapedHook: cB.escapedHook,
+		Width:       cB.box.width,
+		Height:      cB.box.height,
+		MinX:        cB.box.minX,
+		MinY:        cB.box.minY,
+		MaxX:        cB.box.maxX,
+		MaxY:        cB.box.maxY,
+		P:           cB.p,
+		StringMode:  cB.stringMode,
+		CompMode:    cB.compMode,
+		DeepSea:     cB.deepSea,
+	}
+	s.Cells = cB.box.nonEmpty()
+	s.Stacks, _ = cB.Stacks()
+	if cB.file != nil {
+		s.File = &SnapshotFile{cB.file.name, cB.file.offset()}
+	}
+	return s
+}
+
+// Restore replaces the state of cB with s, and clears its journal. If s has a file open, it's reopened in cB's
+// FileSystem and read up to where it was. On error, cB is left unchanged.
+func (cB *CodeBox) Restore(s *Snapshot) error {
+	if s.Version != SnapshotVersion {
+		return fmt.Errorf("%w: version %d, expected %d", ErrInvalidSnapshot, s.Version, SnapshotVersion)
+	}
+	if err := s.check(); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSnapshot, err)
+	}
+
+	var file *openFile
+	if s.File != nil {
+		var err error
+		if file, err = cB.reopen(s.File); err != nil {
+			return err
+		}
+	}
+	if cB.file != nil {
+		cB.file.rc.Close()
+	}
+	cB.file = file
+
+	box := emptyCells(s.Width, s.Height)
+	box.minX, box.minY, box.maxX, box.maxY = s.MinX, s.MinY, s.MaxX, s.MaxY
+	for _, c := range s.Cells {
+		if box.inCore(c.X, c.Y) {
+			box.core[c.Y][c.X] = c.Value
+		} else {
+			box.set(c.X, c.Y, c.Value)
+		}
+	}
+	box.predecode()
+	cB.box = box
+
+	cB.stacks = make([]*Stack, len(s.Stacks))
+	for i, st := range s.Stacks {
+		cB.stacks[i] = cB.newStack(st.Values)
+		if st.Register != nil {
+			cB.stacks[i].register = *st.Register
+			cB.stacks[i].filledRegister = true
+		}
+	}
+	cB.tick = s.Tick
+	cB.journal.reset()
+	cB.fX, cB.fY, cB.fDir = s.X, s.Y, s.Dir
+	cB.wasLeft, cB.escapedHook = s.WasLeft, s.EscapedHook
+	cB.p = s.P
+	cB.stringMode = s.StringMode
+	cB.compMode = s.CompMode
+	cB.deepSea = s.DeepSea
+	return nil
+}
+
+// check returns an error if s isn't a state a CodeBox could be in.
+func (s *Snapshot) check() error {
+	switch {
+	case s.Width < 0 || s.Height < 0:
+		return errors.New("negative script size")
+	case s.Height > 0 && s.Width > maxSnapshotScript/s.Height:
+		return fmt.Errorf("script of %dx%d cells is too big", s.Width, s.Height)
+	case s.MinX > 0 || s.MinY > 0 || s.MaxX < s.Width-1 || s.MaxY < s.Height-1 || s.MinX > s.MaxX || s.MinY > s.MaxY:
+		return errors.New("bounds don't cover the script")
+	case s.X < s.MinX || s.X > s.MaxX || s.Y < s.MinY || s.Y > s.MaxY:
+		return fmt.Errorf("fish at %d,%d is outside the codebox", s.X, s.Y)
+	case s.Dir > Up:
+		return fmt.Errorf("unknown direction %d", s.Dir)
+	case s.P < 0 || s.P >= len(s.Stacks):
+		return fmt.Errorf("no stack %d", s.P)
+	}
+	for _, c := range s.Cells {
+		if c.X < s.MinX || c.X > s.MaxX || c.Y < s.MinY || c.Y > s.MaxY {
+			return fmt.Errorf("cell %d,%d is outside the codebox", c.X, c.Y)
+		}
+	}
+	return nil
+}
+
+// reopen opens f in cB's FileSystem and reads up to where it was.
+func (cB *CodeBox) reopen(f *SnapshotFile) (*openFile, error) {
+	if cB.fsys == nil {
+		return nil, ErrFilesDisabled
+	}
+	rc, err := cB.fsys.Open(f.Name)
+	if err != nil {
+		return nil, err
+	}
+	file := newOpenFile(f.Name, rc)
+	if _, err := io.CopyN(ioutil.Discard, file.r, f.Offset); err != nil && err != io.EOF {
+		rc.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// Clone returns a deep copy of cB. The clone has the same configuration as cB: it reads from the same input,
+// so the two compete for it once either executes "i", and writes to the same output, which is flushed first. Its "x" is seeded from
+// cB's, its journal starts empty, and it has its own copy of cB's breakpoints. If "F" has a file open, the
+// clone opens its own copy of it, which can fail.
+func (cB *CodeBox) Clone() (*CodeBox, error) {
+	cB.Flush()
+	cB.source()
+	c := *cB
+	c.file = nil
+	c.ctx = nil
+	c.traced = nil
+	if cB.prof != nil {
+		WithProfile()(&c)
+	}
+	c.rand = newRand(cB.rand.Int63())
+	if cB.journal != nil {
+		c.journal = &journal{limit: cB.journal.limit}
+	}
+	c.breakpoints = cB.Breakpoints()
+	if cB.out != nil {
+		c.out = bufio.NewWriter(cB.outW)
+	}
+	if err := c.Restore(cB.Snapshot()); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
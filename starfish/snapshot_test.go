@@ -0,0 +1,210 @@
+package starfish
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"math/big"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// SNAPSHOTSCRIPT uses string mode, the register and a second stack, and writes outside the codebox.
+const SNAPSHOTSCRIPT = `1&"ab"2[a001-p]&nooo;`
+
+// runTicks runs cB for n ticks, failing on any error.
+func runTicks(t *testing.T, cB *CodeBox, n int64) {
+	if res, err := cB.Run(context.Background(), RunOptions{MaxTicks: n}); err != nil {
+		t.Fatal(err)
+	} else if res.Reason != TickLimit {
+		t.Fatalf("expected to run %d ticks, stopped after %d: %v", n, res.Ticks, res.Reason)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	var want bytes.Buffer
+	runscript(SNAPSHOTSCRIPT, []float64{7}, false, WithOutput(&want))
+
+	for ticks := int64(1); ticks < 21; ticks++ {
+		cB := NewCodeBox(SNAPSHOTSCRIPT, []float64{7}, false, WithOutput(new(bytes.Buffer)))
+		runTicks(t, cB, ticks)
+		data, err := json.Marshal(cB.Snapshot())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s Snapshot
+		if err = json.Unmarshal(data, &s); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(&s, cB.Snapshot()) {
+			t.Errorf("tick %d: snapshot changed when encoded:\n%+v\n%+v", ticks, &s, cB.Snapshot())
+		}
+
+		var got bytes.Buffer
+		restored := NewCodeBox(";", nil, false, WithOutput(&got))
+		if err = restored.Restore(&s); err != nil {
+			t.Fatal(err)
+		}
+		cB.Run(context.Background(), RunOptions{})
+		restored.Run(context.Background(), RunOptions{})
+		if !reflect.DeepEqual(restored.Snapshot(), cB.Snapshot()) {
+			t.Errorf("tick %d: restored ><> ended in a different state", ticks)
+		}
+		if !bytes.HasSuffix(want.Bytes(), got.Bytes()) {
+			t.Errorf("tick %d: expected the end of %q, got %q", ticks, want.String(), got.String())
+		}
+	}
+}
+
+// TestSnapshotFarCell checks a cell written far outside the script doesn't make Snapshot, and so Clone, look at
+// every cell between it and the script.
+func TestSnapshotFarCell(t *testing.T) {
+	cB := runscript("1ff*:*:*0p;", nil, false)
+	clone, err := cB.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := clone.Snapshot()
+	far := 225 * 225 * 225 * 225
+	if s.MaxX != far {
+		t.Errorf("expected the codebox to reach %d, got %d", far, s.MaxX)
+	}
+	if last := s.Cells[len(s.Cells)-1]; last.X != far || last.Y != 0 || last.Value.Int64() != 1 {
+		t.Errorf("expected the far cell last, got %+v", last)
+	}
+	if len(s.Cells) != 12 {
+		t.Errorf("expected 12 cells, got %d", len(s.Cells))
+	}
+}
+
+func TestClone(t *testing.T) {
+	var out1, out2 bytes.Buffer
+	cB := NewCodeBox(SNAPSHOTSCRIPT, []float64{7}, false, WithOutput(&out1))
+	runTicks(t, cB, 8)
+	clone, err := cB.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clone.out.Reset(&out2)
+	s := clone.Snapshot()
+	cB.Run(context.Background(), RunOptions{})
+	if !reflect.DeepEqual(clone.Snapshot(), s) {
+		t.Errorf("running the original changed the clone")
+	}
+	clone.Run(context.Background(), RunOptions{})
+	if out1.String() != out2.String() {
+		t.Errorf("expected %q from the clone, got %q", out1.String(), out2.String())
+	}
+}
+
+// readCounter counts the calls to its Read method.
+type readCounter struct {
+	r     io.Reader
+	reads int32
+}
+
+func (r *readCounter) Read(p []byte) (int, error) {
+	atomic.AddInt32(&r.reads, 1)
+	return r.r.Read(p)
+}
+
+// TestCloneSharesInput checks cloning doesn't start reading the input, and the clones share it once they do.
+func TestCloneSharesInput(t *testing.T) {
+	in := &readCounter{r: strings.NewReader("ab")}
+	cB := NewCodeBox("i;", nil, false, WithInput(in), WithInputMode(Blocking))
+	clone, err := cB.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if atomic.LoadInt32(&in.reads) != 0 {
+		t.Fatal("Clone started reading the input")
+	}
+	clone.Run(context.Background(), RunOptions{})
+	cB.Run(context.Background(), RunOptions{})
+	if s := clone.Stack(); len(s) != 1 || s[0] != 'a' {
+		t.Errorf("expected the clone to read 'a', got %v", s)
+	}
+	if s := cB.Stack(); len(s) != 1 || s[0] != 'b' {
+		t.Errorf("expected the original to read 'b', got %v", s)
+	}
+}
+
+func TestSnapshotOpenFile(t *testing.T) {
+	fsys := new(MemFS)
+	fsys.WriteFile("a", []byte("xyz"))
+	cB := NewCodeBox(`"a"1Fi~i;`, nil, false, WithFileSystem(fsys))
+	runTicks(t, cB, 7)
+	s := cB.Snapshot()
+	if s.File == nil || s.File.Name != "a" || s.File.Offset != 1 {
+		t.Fatalf("expected file a at offset 1, got %+v", s.File)
+	}
+
+	restored := NewCodeBox(";", nil, false, WithFileSystem(fsys))
+	if err := restored.Restore(s); err != nil {
+		t.Fatal(err)
+	}
+	restored.Run(context.Background(), RunOptions{})
+	if st := restored.Stack(); len(st) != 1 || st[0] != 'y' {
+		t.Errorf("expected to read 'y' after restoring, got %v", st)
+	}
+
+	err := NewCodeBox(";", nil, false, WithFileSystem(nil)).Restore(s)
+	if !errors.Is(err, ErrFilesDisabled) {
+		t.Errorf("expected %v, got %v", ErrFilesDisabled, err)
+	}
+}
+
+func TestRestoreInvalid(t *testing.T) {
+	cB := NewCodeBox("12;", nil, false)
+	s := cB.Snapshot()
+	s.Version = SnapshotVersion + 1
+	if err := cB.Restore(s); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("expected %v for a newer version, got %v", ErrInvalidSnapshot, err)
+	}
+	s = cB.Snapshot()
+	s.P = 1
+	if err := cB.Restore(s); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("expected %v for a missing stack, got %v", ErrInvalidSnapshot, err)
+	}
+	s = cB.Snapshot()
+	s.X = 3
+	if err := cB.Restore(s); !errors.Is(err, ErrInvalidSnapshot) {
+		t.Errorf("expected %v for a fish outside the codebox, got %v", ErrInvalidSnapshot, err)
+	}
+	for _, size := range [][2]int{{1 << 30, 1 << 30}, {math.MaxInt64, 2}, {maxSnapshotScript + 1, 1}} {
+		s = cB.Snapshot()
+		s.Width, s.Height = size[0], size[1]
+		s.MaxX, s.MaxY = size[0]-1, size[1]-1
+		if err := cB.Restore(s); !errors.Is(err, ErrInvalidSnapshot) {
+			t.Errorf("expected %v for a %dx%d script, got %v", ErrInvalidSnapshot, size[0], size[1], err)
+		}
+	}
+}
+
+func TestValueJSON(t *testing.T) {
+	huge, _ := new(big.Rat).SetString("123456789012345678901234567890")
+	for _, v := range []Value{Int(-3), Float(2), Float(0.5), Float(1e300), Float(math.Inf(-1)), Rat(big.NewRat(-7, 2)), Rat(huge)} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got Value
+		if err = json.Unmarshal(data, &got); err != nil {
+			t.Fatalf("%s: %v", data, err)
+		}
+		if got.kind != v.kind || got.String() != v.String() {
+			t.Errorf("%v encoded as %s and decoded as %v", v, data, got)
+		}
+	}
+	var nan Value
+	if err := json.Unmarshal([]byte(`"NaN"`), &nan); err != nil || !math.IsNaN(nan.Float64()) {
+		t.Errorf("expected NaN, got %v: %v", nan, err)
+	}
+}
@@ -0,0 +1,260 @@
+package starfish
+
+// Stack is a type representing a stack in ><>. It holds the stack values, as well as a register. The register
+// may contain data, but will only be considered filled if filledRegister is also true.
+//
+// The values are kept in a ring buffer, along with which end of it is the top, so values can be added and
+// removed at both ends, and the stack reversed, without moving the others.
+type Stack struct {
+	ring           []Value // The values, in a buffer whose length is 0 or a power of two
+	head           int     // The index in ring of the bottom value, or of the top value if flipped
+	n              int     // How many values the stack holds
+	flipped        bool    // Set if the stack runs backward through ring
+	register       Value
+	filledRegister bool
+	log            *journal // Records changes to the stack for CodeBox.StepBack, if set
+	max            int      // The most values the stack has held, for profiles
+}
+
+// NewStack returns a pointer to a Stack populated with s.
+func NewStack(s []Value) *Stack {
+	size := 0
+	if len(s) > 0 {
+		size = 1
+		for size < len(s) {
+			size *= 2
+		}
+	}
+	ring := make([]Value, size)
+	copy(ring, s)
+	return &Stack{ring: ring, n: len(s), max: len(s)}
+}
+
+// S returns a copy of the stack's values, from the bottom up. Stacks used to expose them as a field named S.
+func (s *Stack) S() []Value {
+	return s.appendTo(nil)
+}
+
+// Len returns how many values the stack holds.
+func (s *Stack) Len() int {
+	return s.n
+}
+
+// appendTo appends the stack's values to vals, from the bottom up, and returns the result.
+func (s *Stack) appendTo(vals []Value) []Value {
+	for i := 0; i < s.n; i++ {
+		vals = append(vals, s.ring[s.index(i)])
+	}
+	return vals
+}
+
+// index returns the index in ring of the value i places from the bottom of the stack.
+func (s *Stack) index(i int) int {
+	if s.flipped {
+		i = s.n - 1 - i
+	}
+	return (s.head + i) & (len(s.ring) - 1)
+}
+
+// top returns the value i places below the top of the stack, which must hold more than i values.
+func (s *Stack) top(i int) *Value {
+	return &s.ring[s.index(s.n-1-i)]
+}
+
+// grow doubles the size of ring, or makes it 4 if it's empty. The values keep their order from head.
+func (s *Stack) grow() {
+	ring := make([]Value, 2*len(s.ring))
+	if len(ring) == 0 {
+		ring = make([]Value, 4)
+	}
+	n := copy(ring, s.ring[s.head:])
+	copy(ring[n:], s.ring[:s.head])
+	s.ring, s.head = ring, 0
+}
+
+// pushBack adds v after the value at the end of ring.
+func (s *Stack) pushBack(v Value) {
+	if s.n == len(s.ring) {
+		s.grow()
+	}
+	s.ring[(s.head+s.n)&(len(s.ring)-1)] = v
+	if s.n++; s.n > s.max {
+		s.max = s.n
+	}
+}
+
+// pushFront adds v before the value at head.
+func (s *Stack) pushFront(v Value) {
+	if s.n == len(s.ring) {
+		s.grow()
+	}
+	s.head = (s.head - 1) & (len(s.ring) - 1)
+	s.ring[s.head] = v
+	if s.n++; s.n > s.max {
+		s.max = s.n
+	}
+}
+
+// popBack removes the value at the end of ring, which mustn't be empty, and returns it.
+func (s *Stack) popBack() Value {
+	s.n--
+	i := (s.head + s.n) & (len(s.ring) - 1)
+	v := s.ring[i]
+	s.ring[i] = Value{} // Let the garbage collector have a big.Rat the value holds
+	return v
+}
+
+// popFront removes the value at head, which mustn't be empty, and returns it.
+func (s *Stack) popFront() Value {
+	v := s.ring[s.head]
+	s.ring[s.head] = Value{}
+	s.head = (s.head + 1) & (len(s.ring) - 1)
+	s.n--
+	return v
+}
+
+// put adds v to the top of the stack without journaling it.
+func (s *Stack) put(v Value) {
+	if s.flipped {
+		s.pushFront(v)
+	} else {
+		s.pushBack(v)
+	}
+}
+
+// take removes the value on the top of the stack, which mustn't be empty, without journaling it.
+func (s *Stack) take() Value {
+	if s.flipped {
+		return s.popFront()
+	}
+	return s.popBack()
+}
+
+// putBottom adds v to the bottom of the stack without journaling it.
+func (s *Stack) putBottom(v Value) {
+	if s.flipped {
+		s.pushBack(v)
+	} else {
+		s.pushFront(v)
+	}
+}
+
+// takeBottom removes the value on the bottom of the stack, which mustn't be empty, without journaling it.
+func (s *Stack) takeBottom() Value {
+	if s.flipped {
+		return s.popBack()
+	}
+	return s.popFront()
+}
+
+// Register implements "&".
+func (s *Stack) Register() {
+	s.log.add(change{op: registerSet, s: s, v: s.register, n: boolInt(s.filledRegister)})
+	if s.filledRegister {
+		s.Push(s.register)
+		s.filledRegister = false
+	} else {
+		s.register = s.Pop()
+		s.filledRegister = true
+	}
+}
+
+// Extend implements ":".
+func (s *Stack) Extend() {
+	s.need(1)
+	s.Push(*s.top(0))
+}
+
+// Reverse implements "r".
+func (s *Stack) Reverse() {
+	s.log.add(change{op: reversed, s: s})
+	s.flipped = !s.flipped
+}
+
+// SwapTwo implements "$".
+func (s *Stack) SwapTwo() {
+	s.need(2)
+	s.log.add(change{op: swappedTwo, s: s})
+	a, b := s.top(0), s.top(1)
+	*a, *b = *b, *a
+}
+
+// SwapThree implements "@": with [1,2,3,4], calling "@" results in [1,4,2,3].
+func (s *Stack) SwapThree() {
+	s.need(3)
+	s.log.add(change{op: swappedThree, s: s})
+	a, b, c := s.top(0), s.top(1), s.top(2)
+	*a, *b, *c = *b, *c, *a
+}
+
+// ShiftRight implements "}".
+func (s *Stack) ShiftRight() {
+	r := s.Pop()
+	s.log.add(change{op: prepended, s: s})
+	s.putBottom(r)
+}
+
+// ShiftLeft implements "{".
+func (s *Stack) ShiftLeft() {
+	s.need(1)
+	r := s.takeBottom()
+	s.log.add(change{op: removedFirst, s: s, v: r})
+	s.Push(r)
+}
+
+// Push appends r to the end of the stack.
+func (s *Stack) Push(r Value) {
+	if s.log != nil {
+		s.log.add(change{op: pushed, s: s})
+	}
+	s.put(r)
+}
+
+// Pop removes the value on the end of the stack and returns it.
+func (s *Stack) Pop() (r Value) {
+	if s.n > 0 {
+		r = s.take()
+		if s.log != nil {
+			s.log.add(change{op: popped, s: s, v: r})
+		}
+	} else {
+		fishy(StackUnderflow, nil)
+	}
+	return
+}
+
+// need panics with a stack underflow unless the stack holds at least n values.
+func (s *Stack) need(n int) {
+	if n < 0 || s.n < n {
+		fishy(StackUnderflow, nil)
+	}
+}
+
+// truncate removes the last n values from the stack and returns them.
+func (s *Stack) truncate(n int) []Value {
+	s.need(n)
+	removed := make([]Value, n)
+	for i := n - 1; i >= 0; i-- {
+		removed[i] = s.take()
+	}
+	s.log.add(change{op: truncated, s: s, vals: removed})
+	return removed
+}
+
+// appendAll appends vals to the end of the stack.
+func (s *Stack) appendAll(vals []Value) {
+	s.log.add(change{op: appended, s: s, n: len(vals)})
+	for _, v := range vals {
+		s.put(v)
+	}
+}
+
+// getBytes removes c values from the stack, then returns them as a byte slice.
+func (s *Stack) getBytes(c int) []byte {
+	sData := s.truncate(c)
+	bData := make([]byte, c)
+	for i, v := range sData {
+		bData[i] = byte(v.Int64())
+	}
+	return bData
+}
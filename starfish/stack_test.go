@@ -0,0 +1,128 @@
+package starfish
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// TestStackMatchesSlice checks a Stack against the slice the stack operations used to be written on, through
+// enough operations to wrap around its ring and grow it, then checks the journal undoes every one of them.
+func TestStackMatchesSlice(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	j := &journal{limit: 1 << 20}
+	s := NewStack([]Value{Int(1), Int(2), Int(3)})
+	s.log = j
+	want := []Value{Int(1), Int(2), Int(3)}
+	var states [][]Value
+	for i := 0; i < 3000; i++ {
+		states = append(states, s.S())
+		j.records = append(j.records, record{start: len(j.changes)})
+		op := "pPr:$@}{&"[r.Intn(9)]
+		if len(want) < 3 {
+			op = 'P'
+		}
+		switch op {
+		case 'P':
+			v := Int(int64(i))
+			s.Push(v)
+			want = append(want, v)
+		case 'p':
+			if v := s.Pop(); v != want[len(want)-1] {
+				t.Fatalf("tick %d: popped %v, expected %v", i, v, want[len(want)-1])
+			}
+			want = want[:len(want)-1]
+		case 'r':
+			s.Reverse()
+			for i, ii := 0, len(want)-1; i < ii; i, ii = i+1, ii-1 {
+				want[i], want[ii] = want[ii], want[i]
+			}
+		case ':':
+			s.Extend()
+			want = append(want, want[len(want)-1])
+		case '$':
+			s.SwapTwo()
+			want[len(want)-1], want[len(want)-2] = want[len(want)-2], want[len(want)-1]
+		case '@':
+			s.SwapThree()
+			n := len(want)
+			want[n-1], want[n-2], want[n-3] = want[n-2], want[n-3], want[n-1]
+		case '}':
+			s.ShiftRight()
+			want = append([]Value{want[len(want)-1]}, want[:len(want)-1]...)
+		case '{':
+			s.ShiftLeft()
+			want = append(want[1:len(want):len(want)], want[0])
+		case '&':
+			if s.filledRegister {
+				want = append(want, s.register)
+			} else {
+				want = want[:len(want)-1]
+			}
+			s.Register()
+		}
+		if got := s.S(); !reflect.DeepEqual(got, want) || s.Len() != len(want) {
+			t.Fatalf("tick %d: after %q got %v, expected %v", i, op, got, want)
+		}
+	}
+
+	cB := &CodeBox{journal: j}
+	for i := len(states) - 1; i >= 0; i-- {
+		cB.StepBack()
+		if got := s.S(); !reflect.DeepEqual(got, states[i]) {
+			t.Fatalf("stepping back to tick %d: got %v, expected %v", i, got, states[i])
+		}
+	}
+}
+
+// benchStack calls op b.N times on a short and a long stack. Every op runs in the same time on both.
+func benchStack(b *testing.B, op func(s *Stack)) {
+	for _, n := range []int{16, 1 << 16} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			vals := make([]Value, n)
+			for i := range vals {
+				vals[i] = Int(int64(i))
+			}
+			s := NewStack(vals)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				op(s)
+			}
+		})
+	}
+}
+
+func BenchmarkStackPushPop(b *testing.B) {
+	benchStack(b, func(s *Stack) {
+		s.Push(Int(1))
+		s.Pop()
+	})
+}
+
+func BenchmarkStackReverse(b *testing.B) {
+	benchStack(b, (*Stack).Reverse)
+}
+
+func BenchmarkStackExtend(b *testing.B) {
+	benchStack(b, func(s *Stack) {
+		s.Extend()
+		s.Pop()
+	})
+}
+
+func BenchmarkStackSwapTwo(b *testing.B) {
+	benchStack(b, (*Stack).SwapTwo)
+}
+
+func BenchmarkStackSwapThree(b *testing.B) {
+	benchStack(b, (*Stack).SwapThree)
+}
+
+func BenchmarkStackShiftRight(b *testing.B) {
+	benchStack(b, (*Stack).ShiftRight)
+}
+
+func BenchmarkStackShiftLeft(b *testing.B) {
+	benchStack(b, (*Stack).ShiftLeft)
+}
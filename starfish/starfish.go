@@ -1,13 +1,20 @@
 // See https://esolangs.org/wiki/Starfish for more info.
+//
+// The "F" instruction reads and writes files. Unless WithFileSystem is used, a CodeBox opens them on the host's
+// filesystem with no restrictions, as "F" always has, so a script can read or replace any file the process can.
+// Untrusted scripts should be run with WithFileSystem(DirFS(dir)) to keep them inside one directory, with a
+// MemFS, or with WithFileSystem(nil) to turn "F" off.
 package starfish
 
 import (
+	"bufio"
+	"context"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"math/rand"
-	"os"
 	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // Direction is a value representing the direction a ><> is swimming.
@@ -20,100 +27,7 @@ const (
 	Up
 )
 
-var reader chan byte
-
-// Stack is a type representing a stack in ><>. It holds the stack values in S, as well as a register. The
-// register may contain data, but will only be considered filled if filledRegister is also true.
-type Stack struct {
-	S              []float64
-	register       float64
-	filledRegister bool
-}
-
-// NewStack returns a pointer to a Stack populated with s.
-func NewStack(s []float64) *Stack {
-	newS := make([]float64, len(s))
-	copy(newS, s)
-	return &Stack{S: newS}
-}
-
-// Register implements "&".
-func (s *Stack) Register() {
-	if s.filledRegister {
-		s.Push(s.register)
-		s.filledRegister = false
-	} else {
-		s.register = s.Pop()
-		s.filledRegister = true
-	}
-}
-
-// Extend implements ":".
-func (s *Stack) Extend() {
-	s.Push(s.S[len(s.S)-1])
-}
-
-// Reverse implements "r".
-func (s *Stack) Reverse() {
-	newS := make([]float64, len(s.S))
-	for i, ii := 0, len(s.S)-1; ii >= 0; i, ii = i+1, ii-1 {
-		newS[i] = s.S[ii]
-	}
-	s.S = newS
-}
-
-// SwapTwo implements "$".
-func (s *Stack) SwapTwo() {
-	s.S[len(s.S)-1], s.S[len(s.S)-2] = s.S[len(s.S)-2], s.S[len(s.S)-1]
-}
-
-// SwapThree implements "@": with [1,2,3,4], calling "@" results in [1,4,2,3].
-func (s *Stack) SwapThree() {
-	s.S[len(s.S)-1], s.S[len(s.S)-2], s.S[len(s.S)-3] = s.S[len(s.S)-2], s.S[len(s.S)-3], s.S[len(s.S)-1]
-}
-
-// ShiftRight implements "}".
-func (s *Stack) ShiftRight() {
-	newS := make([]float64, 1, len(s.S))
-	newS[0] = s.Pop()
-	s.S = append(newS, s.S...)
-}
-
-// ShiftLeft implements "{".
-func (s *Stack) ShiftLeft() {
-	r := s.S[0]
-	s.S = s.S[1:]
-	s.Push(r)
-}
-
-// Push appends r to the end of the stack.
-func (s *Stack) Push(r float64) {
-	s.S = append(s.S, r)
-}
-
-// Pop removes the value on the end of the stack and returns it.
-func (s *Stack) Pop() (r float64) {
-	if len(s.S) > 0 {
-		r = s.S[len(s.S)-1]
-		s.S = s.S[:len(s.S)-1]
-	} else {
-		panic("Stack is empty!")
-	}
-	return
-}
-
-// getBytes removes c values from the stack, then returns them as a byte slice.
-func (s *Stack) getBytes(c int) []byte {
-	sData := s.S[len(s.S)-c:]
-	s.S = s.S[:len(s.S)-c]
-	bData := make([]byte, c)
-	for i, v := range sData {
-		bData[i] = byte(v)
-	}
-	return bData
-}
-
-func longestLineLength(lines []string) (l int) {
+func longestLineLength(lines [][]rune) (l int) {
 	for _, s := range lines {
 		if len(s) > l {
 			l = len(s)
@@ -125,23 +39,57 @@ func longestLineLength(lines []string) (l int) {
 // CodeBox is an object usually created with NewCodeBox. It contains a ><> program complete with a stack,
 // and is typically run in steps via CodeBox.Swim.
 type CodeBox struct {
-	fX, fY        int
-	fDir          Direction
-	wasLeft       bool
-	escapedHook   bool
-	width, height int
-	box           [][]byte
-	stacks        []*Stack
-	p             int // Used to keep track of the current stack
-	stringMode    byte
-	compMode      bool
-	deepSea       bool
-	file          *os.File
-}
+	fX, fY      int
+	fDir        Direction
+	wasLeft     bool
+	escapedHook bool
+	box         cells
+	stacks      []*Stack
+	p           int // Used to keep track of the current stack
+	stringMode  byte
+	compMode    bool
+	deepSea     bool
+	fsys        FileSystem
+	file        *openFile
+	in          io.Reader // Set by WithInput
+	input       *input    // Made from in when it's first needed, and shared with clones
+	inMode      InputMode
+	scriptEnc   Encoding
+	inEnc       Encoding
+	outEnc      Encoding
+	out         *bufio.Writer
+	outW        io.Writer       // The writer out buffers
+	ctx         context.Context // Set while Run is running
+	clock       Clock
+	rand        *rand.Rand
+	num         Numeric
+	tick        int64    // Number of ticks executed
+	journal     *journal // Set by WithJournal
+
+	breakpoints    []Breakpoint
+	nextID         int
+	pausedAt       int64 // 1 more than the tick Run last paused before, so it doesn't pause there again
+	wroteCell      bool  // Set if the last tick wrote to a cell
+	wrote          point // The cell the last tick wrote to
+	filledRegister bool  // Set if the last tick filled a register
+
+	trace  func(TraceRecord) // Set by WithTrace
+	traced []byte            // Output of the current tick, if tracing
+	prof   *profiler         // Set by WithProfile
+	// Set if the CodeBox has a journal, trace or profile, which Swim has to keep up to date every tick
+	watched bool
+	floats  bool // Set if num is Float64Numeric, so arithmetic can be done on float64s directly
+}
+
+// Option configures a CodeBox created with NewCodeBox.
+type Option func(*CodeBox)
 
 // NewCodeBox returns a pointer to a new CodeBox. "script" should be a complete ><> script, "stack" should
-// be the initial stack, and compatibilityMode should be set if fishinterpreter.com behaviour is needed.
-func NewCodeBox(script string, stack []float64, compatibilityMode bool) *CodeBox {
+// be the initial stack, and compatibilityMode should be set if fishinterpreter.com behaviour is needed. The only
+// difference it makes is that "[" and "]" reverse the stack they move; arithmetic and coordinates follow the spec
+// in both modes. Any opts are applied to the new CodeBox before it's returned, and the initial stack is
+// converted to its Numeric.
+func NewCodeBox(script string, stack []float64, compatibilityMode bool, opts ...Option) *CodeBox {
 	cB := new(CodeBox)
 
 	script = strings.Replace(script, "\r", "", -1)
@@ -149,48 +97,64 @@ func NewCodeBox(script string, stack []float64, compatibilityMode bool) *CodeBox
 		panic("Cannot accept script of length 0 (No room for the fish to survive).")
 	}
 
-	lines := strings.Split(script, "\n")
-	cB.width = longestLineLength(lines)
-	cB.height = len(lines)
-
-	cB.box = make([][]byte, cB.height)
-	for i, s := range lines {
-		cB.box[i] = make([]byte, cB.width)
-		for ii, r := 0, byte(0); ii < cB.width; ii++ {
-			if ii < len(s) {
-				r = byte(s[ii])
-			} else {
-				r = ' '
-			}
-			cB.box[i][ii] = byte(r)
-		}
+	values := make([]Value, len(stack))
+	for i, f := range stack {
+		values[i] = Float(f)
 	}
-
-	cB.stacks = []*Stack{NewStack(stack)}
+	cB.stacks = []*Stack{NewStack(values)}
+	cB.num = Float64Numeric{}
 	cB.compMode = compatibilityMode
+	cB.fsys = hostFS{}
+	cB.clock = SystemClock{}
+	cB.outEnc = UTF8
+	for _, opt := range opts {
+		opt(cB)
+	}
+	if cB.rand == nil {
+		cB.rand = newRand(time.Now().UnixNano())
+	}
+
+	s := cB.stacks[0]
+	for i := 0; i < s.n; i++ {
+		v := &s.ring[s.index(i)]
+		*v = cB.num.Convert(*v)
+	}
+	s.log = cB.journal
+	cB.watched = cB.journal != nil || cB.trace != nil || cB.prof != nil
+	_, cB.floats = cB.num.(Float64Numeric)
+	cB.box = newCells(strings.Split(script, "\n"), cB.scriptEnc, cB.num)
 
 	return cB
 }
 
-// Exe executes the instruction the ><> is currently on top of. It returns the string it intends to output (nil if none) and true when it executes ";".
-func (cB *CodeBox) Exe(r byte) (string, bool) {
+// deepSeaOps is true for the instructions that are still executed in deep sea mode.
+var deepSeaOps = [256]bool{
+	' ': true, 0: true, '>': true, 'v': true, '<': true, '^': true, '|': true, '_': true, '#': true, '/': true,
+	'\\': true, 'x': true, 'O': true, '`': true,
+}
+
+// Exe executes the instruction the ><> is currently on top of. It returns true when it executes ";".
+func (cB *CodeBox) Exe(r byte) bool {
+	if cB.deepSea && !deepSeaOps[r] {
+		return false
+	}
 	switch r {
-	case ' ':
-		return "", false
+	case ' ', 0:
+		return false
 	case '>':
 		cB.fDir = Right
 		cB.wasLeft = false
-		return "", false
+		return false
 	case 'v':
 		cB.fDir = Down
-		return "", false
+		return false
 	case '<':
 		cB.fDir = Left
 		cB.wasLeft = true
-		return "", false
+		return false
 	case '^':
 		cB.fDir = Up
-		return "", false
+		return false
 	case '|':
 		if cB.fDir == Right {
 			cB.fDir = Left
@@ -199,14 +163,14 @@ func (cB *CodeBox) Exe(r byte) (string, bool) {
 			cB.fDir = Right
 			cB.wasLeft = false
 		}
-		return "", false
+		return false
 	case '_':
 		if cB.fDir == Down {
 			cB.fDir = Up
 		} else if cB.fDir == Up {
 			cB.fDir = Down
 		}
-		return "", false
+		return false
 	case '#':
 		switch cB.fDir {
 		case Right:
@@ -220,7 +184,7 @@ func (cB *CodeBox) Exe(r byte) (string, bool) {
 		case Up:
 			cB.fDir = Down
 		}
-		return "", false
+		return false
 	case '/':
 		switch cB.fDir {
 		case Right:
@@ -234,7 +198,7 @@ func (cB *CodeBox) Exe(r byte) (string, bool) {
 			cB.fDir = Right
 			cB.wasLeft = false
 		}
-		return "", false
+		return false
 	case '\\':
 		switch cB.fDir {
 		case Right:
@@ -248,20 +212,20 @@ func (cB *CodeBox) Exe(r byte) (string, bool) {
 			cB.fDir = Left
 			cB.wasLeft = true
 		}
-		return "", false
+		return false
 	case 'x':
-		cB.fDir = Direction(rand.Int31n(4))
+		cB.fDir = Direction(cB.rand.Int31n(4))
 		switch cB.fDir {
 		case Right:
 			cB.wasLeft = false
 		case Left:
 			cB.wasLeft = true
 		}
-		return "", false
+		return false
 	// *><> commands
 	case 'O':
 		cB.deepSea = false
-		return "", false
+		return false
 	case '`':
 		if cB.fDir == Down || cB.fDir == Up {
 			if cB.wasLeft {
@@ -278,20 +242,11 @@ func (cB *CodeBox) Exe(r byte) (string, bool) {
 				cB.escapedHook = true
 			}
 		}
-		return "", false
-	}
-
-	if cB.deepSea {
-		return "", false
-	}
-
-	var output string
-
-	switch r {
+		return false
 	default:
-		panic(string(r))
+		fishy(UnknownInstruction, nil)
 	case ';':
-		return "", true
+		return true
 	case '"', '\'':
 		if cB.stringMode == 0 {
 			cB.stringMode = r
@@ -299,64 +254,70 @@ func (cB *CodeBox) Exe(r byte) (string, bool) {
 			cB.stringMode = 0
 		}
 	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
-		cB.Push(float64(r - '0'))
+		cB.Push(cB.num.FromInt(int64(r - '0')))
 	case 'a', 'b', 'c', 'd', 'e', 'f':
-		cB.Push(float64(r - 'a' + 10))
+		cB.Push(cB.num.FromInt(int64(r - 'a' + 10)))
 	case '&':
 		cB.Register()
 	case 'o':
-		output = string(rune(cB.Pop()))
+		cB.writeValue(cB.Pop())
 	case 'n':
-		output = fmt.Sprintf("%v", cB.Pop())
+		n := cB.Pop().String()
+		cB.writer().WriteString(n)
+		cB.traceString(n)
 	case 'r':
 		cB.ReverseStack()
 	case '+':
-		cB.Push(cB.Pop() + cB.Pop())
+		x := cB.Pop()
+		y := cB.Pop()
+		cB.Push(cB.num.Add(y, x))
 	case '-':
 		x := cB.Pop()
 		y := cB.Pop()
-		cB.Push(y - x)
+		cB.Push(cB.num.Sub(y, x))
 	case '*':
-		cB.Push(cB.Pop() * cB.Pop())
+		x := cB.Pop()
+		y := cB.Pop()
+		cB.Push(cB.num.Mul(y, x))
 	case ',':
 		x := cB.Pop()
 		y := cB.Pop()
-		cB.Push(y / x)
+		v, err := cB.num.Div(y, x)
+		if err != nil {
+			fishy(DivisionByZero, nil)
+		}
+		cB.Push(v)
 	case '%':
 		x := cB.Pop()
 		y := cB.Pop()
-		cB.Push(float64(int64(y) % int64(x)))
-	case '=':
-		if cB.Pop() == cB.Pop() {
-			cB.Push(1)
-		} else {
-			cB.Push(0)
+		v, err := cB.num.Mod(y, x)
+		if err != nil {
+			fishy(DivisionByZero, nil)
 		}
+		cB.Push(v)
+	case '=':
+		c, ok := compare(cB.Pop(), cB.Pop())
+		cB.pushBool(ok && c == 0)
 	case ')':
 		x := cB.Pop()
 		y := cB.Pop()
-		if y > x {
-			cB.Push(1)
-		} else {
-			cB.Push(0)
-		}
+		c, ok := compare(y, x)
+		cB.pushBool(ok && c > 0)
 	case '(':
 		x := cB.Pop()
 		y := cB.Pop()
-		if y < x {
-			cB.Push(1)
-		} else {
-			cB.Push(0)
-		}
+		c, ok := compare(y, x)
+		cB.pushBool(ok && c < 0)
 	case '!':
 		cB.Move()
 	case '?':
-		if cB.Pop() == 0 {
+		if cB.Pop().IsZero() {
 			cB.Move()
 		}
 	case '.':
-		cB.fY = int(cB.Pop())
-		cB.fX = int(cB.Pop())
+		y := cB.coord(cB.Pop())
+		x := cB.coord(cB.Pop())
+		cB.jump(x, y)
 	case ':':
 		cB.ExtendStack()
 	case '~':
@@ -372,72 +333,59 @@ func (cB *CodeBox) Exe(r byte) (string, bool) {
 	case ']':
 		cB.CloseStack()
 	case '[':
-		cB.NewStack(int(cB.Pop()))
+		cB.NewStack(cB.Pop().int())
 	case 'l':
-		cB.Push(cB.StackLength())
+		cB.Push(cB.num.FromInt(int64(cB.stack().Len())))
 	case 'g':
-		cB.Push(float64(cB.box[int(cB.Pop())][int(cB.Pop())]))
+		y := cB.coord(cB.Pop())
+		x := cB.coord(cB.Pop())
+		cB.Push(cB.num.Convert(cB.box.get(x, y)))
 	case 'p':
-		cB.box[int(cB.Pop())][int(cB.Pop())] = byte(cB.Pop())
+		y := cB.coord(cB.Pop())
+		x := cB.coord(cB.Pop())
+		v := cB.Pop()
+		cB.setCell(x, y, v)
 	case 'i':
-		r := float64(-1)
+		r := rune(-1)
 		if cB.file == nil {
-			b := byte(0)
-			select {
-			case b = <-reader:
-				r = float64(b)
-			default:
-			}
-		} else {
-			bs := []byte{0}
-			n, _ := cB.file.Read(bs)
-			if n > 0 {
-				r = float64(bs[0])
+			cB.Flush()
+			if c, ok := cB.readInput(); ok {
+				r = c
 			}
+		} else if c, err := readValue(cB.file.r, cB.inEnc); err == nil {
+			r = c
 		}
-		cB.Push(r)
+		cB.Push(cB.num.FromInt(int64(r)))
 	// *><> commands
 	case 'h':
-		cB.Push(float64(time.Now().Hour()))
+		cB.Push(cB.num.FromInt(int64(cB.clock.Now().Hour())))
 	case 'm':
-		cB.Push(float64(time.Now().Minute()))
+		cB.Push(cB.num.FromInt(int64(cB.clock.Now().Minute())))
 	case 's':
-		cB.Push(float64(time.Now().Second()))
+		cB.Push(cB.num.FromInt(int64(cB.clock.Now().Second())))
 	case 'S':
-		time.Sleep(time.Millisecond * 100 * time.Duration(cB.Pop()))
+		cB.Flush()
+		cB.clock.Sleep(cB.context(), time.Millisecond*100*time.Duration(cB.Pop().Int64()))
 	case 'u':
 		cB.deepSea = true
 	case 'F':
-		var err error
-		count := int(cB.Pop())
-		bData := cB.stacks[cB.p].getBytes(count)
-		if cB.file != nil {
-			cB.file.Close()
-			err = ioutil.WriteFile(cB.file.Name(), bData, os.ModePerm)
-			if err != nil {
-				panic(err)
-			}
-			cB.file = nil
-		} else {
-			fName := string(bData)
-			cB.file, err = os.Open(fName)
-			if err != nil {
-				cB.file, err = os.Create(fName)
-				if err != nil {
-					panic(err)
-				}
-			}
-		}
+		cB.fileOp()
 	case 'C':
 		cB.Call()
 	case 'R':
 		cB.Ret()
 	case 'I':
+		if cB.p+1 >= len(cB.stacks) {
+			fishy(StackUnderflow, nil)
+		}
 		cB.p++
 	case 'D':
+		if cB.p < 1 {
+			fishy(StackUnderflow, nil)
+		}
 		cB.p--
 	}
-	return output, false
+	return false
 }
 
 // Move changes the fish's x/y coordinates based on CodeBox.fDir.
@@ -445,118 +393,265 @@ func (cB *CodeBox) Move() {
 	switch cB.fDir {
 	case Right:
 		cB.fX++
-		if cB.fX >= cB.width {
-			cB.fX = 0
+		if cB.fX > cB.box.maxX {
+			cB.fX = cB.box.minX
 		}
 	case Down:
 		cB.fY++
-		if cB.fY >= cB.height {
-			cB.fY = 0
+		if cB.fY > cB.box.maxY {
+			cB.fY = cB.box.minY
 		}
 	case Left:
 		cB.fX--
-		if cB.fX < 0 {
-			cB.fX = cB.width - 1
+		if cB.fX < cB.box.minX {
+			cB.fX = cB.box.maxX
 		}
 	case Up:
 		cB.fY--
-		if cB.fY < 0 {
-			cB.fY = cB.height - 1
+		if cB.fY < cB.box.minY {
+			cB.fY = cB.box.maxY
 		}
 	}
 }
 
-// Swim causes the ><> to execute an instruction, then move. It returns a string of non-zero length when it has output and true when it encounters ";".
-func (cB *CodeBox) Swim() (string, bool) {
+// coord returns v as a coordinate for ".", "C", "g" and "p", and panics if v isn't an integer.
+func (cB *CodeBox) coord(v Value) int {
+	i, ok := v.exactInt()
+	if !ok || int64(int(i)) != i {
+		fishy(BadCoordinate, fmt.Errorf("%v is not an integer", v))
+	}
+	return int(i)
+}
+
+// checkJump panics unless x, y is a valid target for "." and "C".
+func (cB *CodeBox) checkJump(x, y int) {
+	if !cB.box.contains(x, y) {
+		fishy(BadJump, fmt.Errorf("%d,%d is outside the codebox", x, y))
+	}
+}
+
+// jump moves the fish to x, y, as done by ".".
+func (cB *CodeBox) jump(x, y int) {
+	cB.checkJump(x, y)
+	cB.fX, cB.fY = x, y
+}
+
+// Swim causes the ><> to execute an instruction, then move. It returns true when it encounters ";". If the
+// instruction cannot be executed, the ><> stays where it is and a *RuntimeError is returned. Buffered output is
+// flushed in both cases. If the context given to Run is done while "i" is waiting for input, the ><> stays where
+// it is and the context's error is returned.
+func (cB *CodeBox) Swim() (end bool, err error) {
+	// Without a journal, trace or profile, an instruction that can't fail with the stack it has needs nothing
+	// recorded or recovered from
+	box := &cB.box
+	if !cB.watched && cB.stringMode == 0 && box.inCore(cB.fX, cB.fY) && uint(cB.p) < uint(len(cB.stacks)) {
+		op := box.ops[cB.fY*box.width+cB.fX]
+		if n := needs[op]; n >= 0 && cB.stacks[cB.p].n >= int(n) {
+			cB.tick++
+			cB.wroteCell, cB.filledRegister = false, false
+			if op > ' ' && !(cB.floats && !cB.deepSea && cB.exeFloat(op)) {
+				end = cB.Exe(op)
+			}
+			cB.Move()
+			if end {
+				return true, cB.Flush()
+			}
+			return false, nil
+		}
+	}
+	return cB.swim()
+}
+
+// swim implements Swim for ticks that are journaled, traced or profiled, or whose instruction may fail.
+func (cB *CodeBox) swim() (end bool, err error) {
+	x, y, dir, mode := cB.fX, cB.fY, cB.fDir, cB.stringMode
+	v := cB.box.get(x, y)
+	r, isOp := cB.box.op(x, y)
+	executed := isOp && (cB.stringMode == 0 || r == cB.stringMode) && (!cB.deepSea || deepSeaOps[r])
+	var start time.Time
+	if cB.prof != nil {
+		start = time.Now()
+	}
+	cB.journal.begin(cB)
+	cB.tick++
+	cB.wroteCell, cB.filledRegister = false, false
 	defer func() {
-		if r := recover(); r != nil {
-			cB.PrintBox()
-			fmt.Println("Stack:", cB.Stack())
-			fmt.Println("something smells fishy...")
-			os.Exit(1)
+		if rec := recover(); rec != nil {
+			if i, ok := rec.(interrupted); ok {
+				cB.fX, cB.fY, cB.fDir = x, y, dir
+				cB.journal.discard()
+				cB.tick--
+				cB.traced = cB.traced[:0]
+				err = i.err
+				return
+			}
+			f, ok := rec.(fault)
+			if !ok {
+				panic(rec)
+			}
+			err = cB.fail(f, x, y, dir)
+		}
+		if cB.prof != nil {
+			cB.prof.count(cB, x, y, r, executed, start)
+		}
+		if cB.trace != nil {
+			cB.traceTick(x, y, dir, mode, v, r, executed && r != 0, end, err)
 		}
 	}()
 
-	var (
-		output string
-		end    bool
-	)
+	if cB.stringMode != 0 && v == (Value{}) {
+		v = cB.num.FromInt(' ') // Traced as the space step pushes
+	}
+	if cB.step(v, r, isOp) {
+		return true, cB.Flush()
+	}
+	return false, nil
+}
 
-	if r := cB.box[cB.fY][cB.fX]; cB.stringMode != 0 && r != cB.stringMode {
-		cB.Push(float64(r))
-	} else {
-		output, end = cB.Exe(r)
+// step executes a cell holding v, which executes as r if isOp is true, then moves the fish. It returns true
+// when the cell ends the ><>.
+func (cB *CodeBox) step(v Value, r byte, isOp bool) (end bool) {
+	if cB.stringMode != 0 && (!isOp || r != cB.stringMode) {
+		if v == (Value{}) {
+			v = cB.num.FromInt(' ') // An empty cell reads as a space in a string
+		}
+		cB.Push(v)
+	} else if isOp {
+		end = cB.Exe(r)
+	} else if !cB.deepSea {
+		fishy(UnknownInstruction, nil)
 	}
 	cB.Move()
-	return output, end
+	return end
+}
+
+// fail returns the RuntimeError for fault f, raised by the instruction at x, y while swimming in dir. It puts
+// the fish back there and flushes the output.
+func (cB *CodeBox) fail(f fault, x, y int, dir Direction) *RuntimeError {
+	v := cB.box.get(x, y)
+	r, _ := opcode(v)
+	err := &RuntimeError{
+		Kind:        f.kind,
+		X:           x,
+		Y:           y,
+		Instruction: r,
+		Cell:        v,
+		Dir:         dir,
+		Stack:       cB.Values(),
+		Err:         f.err,
+	}
+	cB.fX, cB.fY, cB.fDir = x, y, dir
+	cB.Flush()
+	return err
 }
 
-// Stack returns the underlying Stack slice.
+// Stack returns a copy of the current stack as float64s. Use Values to get the exact values.
 func (cB *CodeBox) Stack() []float64 {
 	if cB.p >= 0 && cB.p < len(cB.stacks) {
-		return cB.stacks[cB.p].S
+		s := make([]float64, 0, cB.stacks[cB.p].Len())
+		for _, v := range cB.stacks[cB.p].S() {
+			s = append(s, v.Float64())
+		}
+		return s
 	} else {
 		return []float64{float64(cB.p)}
 	}
 }
 
+// Values returns a copy of the current stack.
+func (cB *CodeBox) Values() []Value {
+	if cB.p >= 0 && cB.p < len(cB.stacks) {
+		return cB.stacks[cB.p].S()
+	}
+	return nil
+}
+
+// Numeric returns the arithmetic the CodeBox uses.
+func (cB *CodeBox) Numeric() Numeric {
+	return cB.num
+}
+
+// stack returns the current stack, or panics with a stack underflow if the stack pointer doesn't point to one.
+func (cB *CodeBox) stack() *Stack {
+	if cB.p < 0 || cB.p >= len(cB.stacks) {
+		fishy(StackUnderflow, nil)
+	}
+	return cB.stacks[cB.p]
+}
+
 // Push appends r to the end of the current stack.
-func (cB *CodeBox) Push(r float64) {
-	cB.stacks[cB.p].Push(r)
+func (cB *CodeBox) Push(r Value) {
+	cB.stack().Push(r)
 }
 
 // Pop removes the value on the end of the current stack and returns it.
-func (cB *CodeBox) Pop() float64 {
-	return cB.stacks[cB.p].Pop()
+func (cB *CodeBox) Pop() Value {
+	return cB.stack().Pop()
 }
 
-// StackLength implements "l" on the current stack.
+// StackLength returns the length of the current stack, as pushed by "l".
 func (cB *CodeBox) StackLength() float64 {
-	return float64(len(cB.stacks[cB.p].S))
+	return float64(cB.stack().Len())
+}
+
+// pushBool pushes 1 if b is true, or 0 if not.
+func (cB *CodeBox) pushBool(b bool) {
+	if b {
+		cB.Push(cB.num.FromInt(1))
+	} else {
+		cB.Push(cB.num.FromInt(0))
+	}
 }
 
 // Register implements "&" on the current stack.
 func (cB *CodeBox) Register() {
-	cB.stacks[cB.p].Register()
+	s := cB.stack()
+	s.Register()
+	cB.filledRegister = s.filledRegister
 }
 
 // ReverseStack implements "r" on the current stack.
 func (cB *CodeBox) ReverseStack() {
-	cB.stacks[cB.p].Reverse()
+	cB.stack().Reverse()
 }
 
 // ExtendStack implements ":" on the current stack.
 func (cB *CodeBox) ExtendStack() {
-	cB.stacks[cB.p].Extend()
+	cB.stack().Extend()
 }
 
 // StackSwapTwo implements "$" on the current stack.
 func (cB *CodeBox) StackSwapTwo() {
-	cB.stacks[cB.p].SwapTwo()
+	cB.stack().SwapTwo()
 }
 
 // StackSwapThree implements "@" on the current stack.
 func (cB *CodeBox) StackSwapThree() {
-	cB.stacks[cB.p].SwapThree()
+	cB.stack().SwapThree()
 }
 
 // StackShiftRight implements "}" on the current stack.
 func (cB *CodeBox) StackShiftRight() {
-	cB.stacks[cB.p].ShiftRight()
+	cB.stack().ShiftRight()
 }
 
 // StackShiftLeft implements "{" on the current stack.
 func (cB *CodeBox) StackShiftLeft() {
-	cB.stacks[cB.p].ShiftLeft()
+	cB.stack().ShiftLeft()
 }
 
 // CloseStack implements "]".
 func (cB *CodeBox) CloseStack() {
+	if cB.p < 1 || cB.p >= len(cB.stacks) {
+		fishy(StackUnderflow, nil)
+	}
+	cB.journal.saveStacks(cB.stacks)
 	cB.p--
 	if cB.compMode {
 		cB.stacks[cB.p+1].Reverse() // This is done to match the fishlanguage.com interpreter...
 	}
-	cB.stacks[cB.p].S = append(cB.stacks[cB.p].S, cB.stacks[cB.p+1].S...)
+	cB.stacks[cB.p].appendAll(cB.stacks[cB.p+1].S())
+	cB.prof.removed(cB.stacks, cB.p+1)
 	if cB.p+2 == len(cB.stacks) {
 		cB.stacks = cB.stacks[:cB.p+1]
 	} else {
@@ -566,17 +661,18 @@ func (cB *CodeBox) CloseStack() {
 
 // NewStack implements "[".
 func (cB *CodeBox) NewStack(n int) {
+	vals := cB.stack().truncate(n)
+	cB.journal.saveStacks(cB.stacks)
 	cB.p++
 	if cB.p == len(cB.stacks) {
-		cB.stacks = append(cB.stacks, NewStack(cB.stacks[cB.p-1].S[len(cB.stacks[cB.p-1].S)-n:]))
+		cB.stacks = append(cB.stacks, cB.newStack(vals))
 	} else {
 		tstacks := make([]*Stack, cB.p+1, len(cB.stacks)+1)
 		copy(tstacks, cB.stacks[:cB.p])
-		tstacks[cB.p] = NewStack(cB.stacks[cB.p-1].S[len(cB.stacks[cB.p-1].S)-n:])
+		tstacks[cB.p] = cB.newStack(vals)
 		tstacks = append(tstacks, cB.stacks[cB.p:]...)
 		cB.stacks = tstacks
 	}
-	cB.stacks[cB.p-1].S = cB.stacks[cB.p-1].S[:len(cB.stacks[cB.p-1].S)-n]
 	if cB.compMode {
 		cB.stacks[cB.p].Reverse() // This is done to match the fishlanguage.com interpreter...
 	}
@@ -584,27 +680,37 @@ func (cB *CodeBox) NewStack(n int) {
 
 // Call implements "C".
 func (cB *CodeBox) Call() {
+	s := cB.stack()
+	s.need(2)
+	cB.checkJump(cB.coord(*s.top(1)), cB.coord(*s.top(0)))
+	cB.journal.saveStacks(cB.stacks)
 	cB.p++
 	if cB.p == len(cB.stacks) {
-		cB.stacks = append(cB.stacks, NewStack([]float64{float64(cB.fX), float64(cB.fY)}))
+		cB.stacks = append(cB.stacks, cB.newStack([]Value{cB.num.FromInt(int64(cB.fX)), cB.num.FromInt(int64(cB.fY))}))
 		cB.stacks[cB.p], cB.stacks[cB.p-1] = cB.stacks[cB.p-1], cB.stacks[cB.p]
 	} else {
 		tstacks := make([]*Stack, cB.p+1, len(cB.stacks)+1)
 		copy(tstacks, cB.stacks[:cB.p])
 		tstacks[cB.p] = tstacks[cB.p-1]
-		tstacks[cB.p-1] = NewStack([]float64{float64(cB.fX), float64(cB.fY)})
+		tstacks[cB.p-1] = cB.newStack([]Value{cB.num.FromInt(int64(cB.fX)), cB.num.FromInt(int64(cB.fY))})
 		tstacks = append(tstacks, cB.stacks[cB.p:]...)
 		cB.stacks = tstacks
 	}
-	cB.fY = int(cB.Pop())
-	cB.fX = int(cB.Pop())
+	cB.fY = cB.Pop().int()
+	cB.fX = cB.Pop().int()
 }
 
 // Ret implements "R".
 func (cB *CodeBox) Ret() {
+	if cB.p < 1 || cB.p >= len(cB.stacks) {
+		fishy(StackUnderflow, nil)
+	}
+	cB.stacks[cB.p-1].need(2)
+	cB.journal.saveStacks(cB.stacks)
 	cB.p--
-	cB.fY = int(cB.Pop())
-	cB.fX = int(cB.Pop())
+	cB.fY = cB.Pop().int()
+	cB.fX = cB.Pop().int()
+	cB.prof.removed(cB.stacks, cB.p)
 	cB.stacks[cB.p] = cB.stacks[cB.p+1]
 	if cB.p+2 == len(cB.stacks) {
 		cB.stacks = cB.stacks[:cB.p+1]
@@ -613,24 +719,39 @@ func (cB *CodeBox) Ret() {
 	}
 }
 
-// PrintBox outputs the codebox to stdout.
+// PrintBox outputs the codebox to stdout, including any cells "p" has written outside the original script.
+// Cells that don't hold a printable character are marked with "�" and their values are listed below the box.
 func (cB *CodeBox) PrintBox() {
 	fmt.Println()
-	for y, line := range cB.box {
-		for x, r := range line {
+	var values []string
+	for i, line := range cB.box.rows() {
+		for ii, r := range line {
+			x, y := cB.box.minX+ii, cB.box.minY+i
+			if r == utf8.RuneError {
+				values = append(values, fmt.Sprintf("%d,%d: %v", x, y, cB.box.get(x, y)))
+			}
 			if x != cB.fX || y != cB.fY {
-				fmt.Print(" " + string(rune(r)) + " ")
+				fmt.Print(" " + string(r) + " ")
 			} else {
-				fmt.Print("*" + string(rune(r)) + "*")
+				fmt.Print("*" + string(r) + "*")
 			}
 		}
 		fmt.Println()
 	}
+	for _, s := range values {
+		fmt.Println(s)
+	}
 }
 
-// Size returns the CodeBox's width/height
+// Size returns the CodeBox's width/height, which grows as "p" writes outside of it.
 func (cB *CodeBox) Size() (int, int) {
-	return cB.width, cB.height
+	return cB.box.maxX - cB.box.minX + 1, cB.box.maxY - cB.box.minY + 1
+}
+
+// Bounds returns the coordinates of the CodeBox's top-left and bottom-right cells. The top-left cell is 0, 0
+// unless "p" has written to negative coordinates.
+func (cB *CodeBox) Bounds() (minX, minY, maxX, maxY int) {
+	return cB.box.minX, cB.box.minY, cB.box.maxX, cB.box.maxY
 }
 
 // Loc returns the CodeBox's x/y
@@ -638,14 +759,31 @@ func (cB *CodeBox) Loc() (int, int) {
 	return cB.fX, cB.fY
 }
 
-// Box returns a copy of the 2D slice containing the *><> script
-func (cB *CodeBox) Box() [][]byte {
-	outBox := make([][]byte, len(cB.box))
-	for i := range cB.box {
-		outBox[i] = make([]byte, len(cB.box[i]))
-		copy(outBox[i], cB.box[i])
-	}
-	return outBox
+// Dir returns the direction the ><> is swimming
+func (cB *CodeBox) Dir() Direction {
+	return cB.fDir
+}
+
+// Cell returns the value of the cell at x, y. Empty cells and cells outside Bounds hold 0.
+func (cB *CodeBox) Cell(x, y int) Value {
+	return cB.box.get(x, y)
+}
+
+// SetCell writes v to the cell at x, y, as "p" does. With a journal, the write is undone along with the last tick.
+func (cB *CodeBox) SetCell(x, y int, v Value) {
+	cB.setCell(x, y, v)
+}
+
+// Box returns the *><> script as a 2D slice of characters, covering every cell inside Bounds. Cells are shown as
+// by CellRune. Box()[0][0] is the cell at minX, minY.
+func (cB *CodeBox) Box() [][]rune {
+	return cB.box.rows()
+}
+
+// Cells returns a copy of the value of every cell inside Bounds. Empty cells hold 0. Cells()[0][0] is the cell
+// at minX, minY.
+func (cB *CodeBox) Cells() [][]Value {
+	return cB.box.values()
 }
 
 // DeepSea returns whether the codebox is in DeepSea mode
@@ -653,22 +791,21 @@ func (cB *CodeBox) DeepSea() bool {
 	return cB.deepSea
 }
 
-func init() {
-	rand.Seed(int64(time.Now().Nanosecond()))
-	reader = make(chan byte, 1024)
-	go func() {
-		var err error
-		b := make([]byte, 1024)
-		for err == nil {
-			n, err := os.Stdin.Read(b)
-			if err == nil {
-				for i := 0; i < n; i++ {
-					reader <- b[i]
-				}
-			} else {
-				fmt.Println(err)
-				return
-			}
+// StringMode returns the quote that turned string mode on, or 0 if it's off.
+func (cB *CodeBox) StringMode() byte {
+	return cB.stringMode
+}
+
+// Stacks returns a copy of every stack, as Snapshot holds them, and the index of the current one. It's cheaper
+// than a Snapshot, as the codebox isn't copied.
+func (cB *CodeBox) Stacks() (stacks []SnapshotStack, p int) {
+	stacks = make([]SnapshotStack, len(cB.stacks))
+	for i, st := range cB.stacks {
+		stacks[i].Values = st.appendTo([]Value{})
+		if st.filledRegister {
+			r := st.register
+			stacks[i].Register = &r
 		}
-	}()
+	}
+	return stacks, cB.p
 }
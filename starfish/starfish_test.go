@@ -1,6 +1,9 @@
 package starfish
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"log"
 	"testing"
 	"time"
@@ -21,17 +24,27 @@ var (
 		float64(' '), float64('w'), float64('o'), float64('r'), float64('l'), float64('d')} // Stack used in "BenchmarkScript"
 )
 
-func runscript(script string, initialstack []float64, compMode bool) *CodeBox {
-	cB := NewCodeBox(script, initialstack, compMode)
-	now := time.Now()
-	for !cB.Swim() {
-		if time.Since(now) >= time.Second {
-			log.Fatalln("script taking too long...")
-		}
+func runscript(script string, initialstack []float64, compMode bool, opts ...Option) *CodeBox {
+	cB := NewCodeBox(script, initialstack, compMode, opts...)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := cB.Run(ctx, RunOptions{}); err == context.DeadlineExceeded {
+		log.Fatalln("script taking too long...")
+	} else if err != nil {
+		log.Fatalln(err)
 	}
 	return cB
 }
 
+// swim calls cB.Swim, failing on a RuntimeError, and reports whether the ><> ended.
+func swim(cB *CodeBox) bool {
+	end, err := cB.Swim()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	return end
+}
+
 func BenchmarkScript(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		b.StopTimer()
@@ -39,7 +52,7 @@ func BenchmarkScript(b *testing.B) {
 		copy(stack, INITIALSTACK)
 		cB := NewCodeBox(SCRIPT, stack, false)
 		b.StartTimer()
-		for !cB.Swim() {
+		for !swim(cB) {
 		}
 	}
 	log.Println(b.N)
@@ -48,11 +61,11 @@ func BenchmarkScript(b *testing.B) {
 func TestStackRegister(t *testing.T) {
 	cB := runscript("&;", []float64{TESTVALUE1, TESTVALUE2, TESTVALUE3}, false)
 	s := cB.stacks[0]
-	if len(s.S) != 2 || s.register != TESTVALUE3 || s.S[0] != TESTVALUE1 || !s.filledRegister {
+	if len(s.S()) != 2 || s.register.Float64() != TESTVALUE3 || s.S()[0].Float64() != TESTVALUE1 || !s.filledRegister {
 		t.FailNow()
 	}
 	s.Register()
-	if len(s.S) != 3 || s.S[0] != TESTVALUE1 || s.S[2] != TESTVALUE3 || s.filledRegister {
+	if len(s.S()) != 3 || s.S()[0].Float64() != TESTVALUE1 || s.S()[2].Float64() != TESTVALUE3 || s.filledRegister {
 		t.FailNow()
 	}
 }
@@ -60,7 +73,7 @@ func TestStackRegister(t *testing.T) {
 func TestStackExtend(t *testing.T) {
 	cB := runscript(":;", []float64{TESTVALUE1, TESTVALUE2}, false)
 	s := cB.stacks[0]
-	if len(s.S) != 3 || s.S[2] != TESTVALUE2 {
+	if len(s.S()) != 3 || s.S()[2].Float64() != TESTVALUE2 {
 		t.FailNow()
 	}
 }
@@ -68,7 +81,7 @@ func TestStackExtend(t *testing.T) {
 func TestStackReverse(t *testing.T) {
 	cB := runscript("r;", []float64{TESTVALUE1, TESTVALUE2, TESTVALUE3}, false)
 	s := cB.stacks[0]
-	if s.S[0] != TESTVALUE3 || s.S[1] != TESTVALUE2 || s.S[2] != TESTVALUE1 {
+	if s.S()[0].Float64() != TESTVALUE3 || s.S()[1].Float64() != TESTVALUE2 || s.S()[2].Float64() != TESTVALUE1 {
 		t.FailNow()
 	}
 }
@@ -76,7 +89,7 @@ func TestStackReverse(t *testing.T) {
 func TestStackSwapTwo(t *testing.T) {
 	cB := runscript("$;", []float64{TESTVALUE1, TESTVALUE2, TESTVALUE3}, false)
 	s := cB.stacks[0]
-	if s.S[0] != TESTVALUE1 || s.S[1] != TESTVALUE3 || s.S[2] != TESTVALUE2 {
+	if s.S()[0].Float64() != TESTVALUE1 || s.S()[1].Float64() != TESTVALUE3 || s.S()[2].Float64() != TESTVALUE2 {
 		t.FailNow()
 	}
 }
@@ -84,7 +97,7 @@ func TestStackSwapTwo(t *testing.T) {
 func TestStackSwapThree(t *testing.T) {
 	cB := runscript("@;", []float64{TESTVALUE1, TESTVALUE2, TESTVALUE3, TESTVALUE4}, false)
 	s := cB.stacks[0]
-	if s.S[0] != TESTVALUE1 || s.S[1] != TESTVALUE4 || s.S[2] != TESTVALUE2 || s.S[3] != TESTVALUE3 {
+	if s.S()[0].Float64() != TESTVALUE1 || s.S()[1].Float64() != TESTVALUE4 || s.S()[2].Float64() != TESTVALUE2 || s.S()[3].Float64() != TESTVALUE3 {
 		t.FailNow()
 	}
 }
@@ -92,7 +105,7 @@ func TestStackSwapThree(t *testing.T) {
 func TestStackShiftLeft(t *testing.T) {
 	cB := runscript("{;", []float64{TESTVALUE1, TESTVALUE2, TESTVALUE3, TESTVALUE4}, false)
 	s := cB.stacks[0]
-	if s.S[0] != TESTVALUE2 || s.S[1] != TESTVALUE3 || s.S[2] != TESTVALUE4 || s.S[3] != TESTVALUE1 {
+	if s.S()[0].Float64() != TESTVALUE2 || s.S()[1].Float64() != TESTVALUE3 || s.S()[2].Float64() != TESTVALUE4 || s.S()[3].Float64() != TESTVALUE1 {
 		t.FailNow()
 	}
 }
@@ -100,39 +113,39 @@ func TestStackShiftLeft(t *testing.T) {
 func TestStackShiftRight(t *testing.T) {
 	cB := runscript("};", []float64{TESTVALUE1, TESTVALUE2, TESTVALUE3, TESTVALUE4}, false)
 	s := cB.stacks[0]
-	if s.S[0] != TESTVALUE4 || s.S[1] != TESTVALUE1 || s.S[2] != TESTVALUE2 || s.S[3] != TESTVALUE3 {
+	if s.S()[0].Float64() != TESTVALUE4 || s.S()[1].Float64() != TESTVALUE1 || s.S()[2].Float64() != TESTVALUE2 || s.S()[3].Float64() != TESTVALUE3 {
 		t.FailNow()
 	}
 }
 
 func TestNewStackCloseStack(t *testing.T) {
 	cB := NewCodeBox("[]", []float64{TESTVALUE1, TESTVALUE2, TESTVALUE3, TESTVALUE4, 2}, false)
-	cB.Swim()
+	swim(cB)
 	s := cB.stacks[0]
 	s2 := cB.stacks[1]
-	if s.S[0] != TESTVALUE1 || s.S[1] != TESTVALUE2 || s2.S[0] != TESTVALUE3 || s2.S[1] != TESTVALUE4 || len(s.S) != 2 || len(s2.S) != 2 {
+	if s.S()[0].Float64() != TESTVALUE1 || s.S()[1].Float64() != TESTVALUE2 || s2.S()[0].Float64() != TESTVALUE3 || s2.S()[1].Float64() != TESTVALUE4 || len(s.S()) != 2 || len(s2.S()) != 2 {
 		t.FailNow()
 	}
 
-	cB.Swim()
+	swim(cB)
 	s = cB.stacks[0]
-	if s.S[0] != TESTVALUE1 || s.S[1] != TESTVALUE2 || s.S[2] != TESTVALUE3 || s.S[3] != TESTVALUE4 || len(s.S) != 4 {
+	if s.S()[0].Float64() != TESTVALUE1 || s.S()[1].Float64() != TESTVALUE2 || s.S()[2].Float64() != TESTVALUE3 || s.S()[3].Float64() != TESTVALUE4 || len(s.S()) != 4 {
 		t.FailNow()
 	}
 }
 
 func TestNewStackCloseStackCompatibility(t *testing.T) {
 	cB := NewCodeBox("[]", []float64{TESTVALUE1, TESTVALUE2, TESTVALUE3, TESTVALUE4, 2}, true)
-	cB.Swim()
+	swim(cB)
 	s := cB.stacks[0]
 	s2 := cB.stacks[1]
-	if s.S[0] != TESTVALUE1 || s.S[1] != TESTVALUE2 || s2.S[1] != TESTVALUE3 || s2.S[0] != TESTVALUE4 || len(s.S) != 2 || len(s2.S) != 2 {
+	if s.S()[0].Float64() != TESTVALUE1 || s.S()[1].Float64() != TESTVALUE2 || s2.S()[1].Float64() != TESTVALUE3 || s2.S()[0].Float64() != TESTVALUE4 || len(s.S()) != 2 || len(s2.S()) != 2 {
 		t.FailNow()
 	}
 
-	cB.Swim()
+	swim(cB)
 	s = cB.stacks[0]
-	if s.S[0] != TESTVALUE1 || s.S[1] != TESTVALUE2 || s.S[2] != TESTVALUE3 || s.S[3] != TESTVALUE4 || len(s.S) != 4 {
+	if s.S()[0].Float64() != TESTVALUE1 || s.S()[1].Float64() != TESTVALUE2 || s.S()[2].Float64() != TESTVALUE3 || s.S()[3].Float64() != TESTVALUE4 || len(s.S()) != 4 {
 		t.FailNow()
 	}
 }
@@ -144,7 +157,7 @@ func TestPrintBox(t *testing.T) {
 
 func TestStackLength(t *testing.T) {
 	cB := NewCodeBox("l;", []float64{TESTVALUE1, TESTVALUE2, TESTVALUE3}, false)
-	cB.Swim()
+	swim(cB)
 	if cB.Stack()[3] != 3 {
 		t.Fail()
 	}
@@ -160,36 +173,143 @@ func TestStackReturn(t *testing.T) {
 
 func TestMovement(t *testing.T) {
 	cB := NewCodeBox(">;", []float64{}, false)
-	cB.Swim()
-	if !cB.Swim() {
+	swim(cB)
+	if !swim(cB) {
 		t.Fail()
 	}
 
 	cB = NewCodeBox("<;", []float64{}, false)
-	cB.Swim()
-	if !cB.Swim() {
+	swim(cB)
+	if !swim(cB) {
 		t.Fail()
 	}
 
 	cB = NewCodeBox("^\n;", []float64{}, false)
-	cB.Swim()
-	if !cB.Swim() {
+	swim(cB)
+	if !swim(cB) {
 		t.Fail()
 	}
 
 	cB = NewCodeBox("v\n;", []float64{}, false)
-	cB.Swim()
-	if !cB.Swim() {
+	swim(cB)
+	if !swim(cB) {
 		t.Fail()
 	}
 
 	cB = NewCodeBox("`;\n`", []float64{}, false)
 	for i := 0; i < 5; i++ {
-		if cB.Swim() {
+		if swim(cB) {
 			t.Fail()
 		}
 	}
-	if !cB.Swim() {
+	if !swim(cB) {
 		t.Fail()
 	}
 }
+
+// opTest is a case for an instruction's table-driven test. Scripts are run in both normal and compatibility
+// mode, which must agree. If kind is set, the script must fail with it; otherwise it must output out.
+type opTest struct {
+	script string
+	stack  []float64
+	out    string
+	kind   ErrorKind
+	fails  bool
+}
+
+func runOpTests(t *testing.T, tests []opTest) {
+	for _, test := range tests {
+		for _, compMode := range []bool{false, true} {
+			var out bytes.Buffer
+			cB := NewCodeBox(test.script, test.stack, compMode, WithOutput(&out))
+			var err error
+			for end, i := false, 0; !end && err == nil && i < 100; i++ {
+				end, err = cB.Swim()
+			}
+			var rErr *RuntimeError
+			switch {
+			case test.fails && (!errors.As(err, &rErr) || rErr.Kind != test.kind):
+				t.Errorf("%q (compMode %v): expected %v, got %v", test.script, compMode, test.kind, err)
+			case !test.fails && err != nil:
+				t.Errorf("%q (compMode %v): %v", test.script, compMode, err)
+			case !test.fails && out.String() != test.out:
+				t.Errorf("%q (compMode %v): expected %q, got %q", test.script, compMode, test.out, out.String())
+			}
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	runOpTests(t, []opTest{
+		{script: "23+n;", out: "5"},
+		{script: "+n;", stack: []float64{1.5, -4}, out: "-2.5"},
+		{script: "1+", fails: true, kind: StackUnderflow},
+	})
+}
+
+func TestSubtract(t *testing.T) {
+	runOpTests(t, []opTest{
+		{script: "23-n;", out: "-1"},
+		{script: "-n;", stack: []float64{0.5, 0.25}, out: "0.25"},
+		{script: "1-", fails: true, kind: StackUnderflow},
+	})
+}
+
+func TestMultiply(t *testing.T) {
+	runOpTests(t, []opTest{
+		{script: "23*n;", out: "6"},
+		{script: "*n;", stack: []float64{-1.5, 3}, out: "-4.5"},
+		{script: "1*", fails: true, kind: StackUnderflow},
+	})
+}
+
+func TestDivide(t *testing.T) {
+	runOpTests(t, []opTest{
+		{script: "84,n;", out: "2"},
+		{script: "23,n;", out: "0.6666666666666666"},
+		{script: "07-2,n;", out: "-3.5"},
+		{script: "10,", fails: true, kind: DivisionByZero},
+		{script: ",", stack: []float64{1, 0.5, 0}, fails: true, kind: DivisionByZero},
+		{script: "1,", fails: true, kind: StackUnderflow},
+	})
+}
+
+func TestModulo(t *testing.T) {
+	runOpTests(t, []opTest{
+		{script: "73%n;", out: "1"},
+		{script: "%n;", stack: []float64{5.5, 2}, out: "1.5"},
+		{script: "07-3%n;", out: "2"},
+		{script: "703-%n;", out: "-2"},
+		{script: "07-03-%n;", out: "-1"},
+		{script: "%n;", stack: []float64{-5.5, 2}, out: "0.5"},
+		{script: "63%n;", out: "0"},
+		{script: "10%", fails: true, kind: DivisionByZero},
+		{script: "1%", fails: true, kind: StackUnderflow},
+	})
+}
+
+func TestJump(t *testing.T) {
+	runOpTests(t, []opTest{
+		{script: "30.;1n;", out: "1"},
+		{script: ".;1n;", stack: []float64{5.5, 0}, fails: true, kind: BadCoordinate},
+		{script: ".;1n;", stack: []float64{5, 0.5}, fails: true, kind: BadCoordinate},
+		{script: "90.", fails: true, kind: BadJump},
+	})
+}
+
+func TestGet(t *testing.T) {
+	runOpTests(t, []opTest{
+		{script: "20gn;", out: "103"},
+		{script: "a0gn;", out: "0"},
+		{script: "g;", stack: []float64{0, 0.5}, fails: true, kind: BadCoordinate},
+		{script: "g;", stack: []float64{1e300, 0}, fails: true, kind: BadCoordinate},
+	})
+}
+
+func TestPut(t *testing.T) {
+	runOpTests(t, []opTest{
+		{script: "9a0pa0gn;", out: "9"},
+		{script: "p;", stack: []float64{1, 0.5, 0}, fails: true, kind: BadCoordinate},
+		{script: "p;", stack: []float64{1, 0, -0.5}, fails: true, kind: BadCoordinate},
+	})
+}
@@ -0,0 +1,99 @@
+package starfish
+
+import (
+	"unicode/utf8"
+)
+
+// TraceTop is how many values from the top of the current stack a TraceRecord holds.
+const TraceTop = 8
+
+// TraceRecord describes one tick of a ><>, for the function given to WithTrace. Trace records can be encoded with
+// encoding/json.
+type TraceRecord struct {
+	Tick        int64         // The tick's number, starting at 1
+	X, Y        int           // The cell the fish executed
+	Dir         Direction     // The direction the fish was swimming when it reached the cell
+	Cell        Value         // The value of the cell
+	Instruction string        `json:",omitempty"` // The instruction executed; empty if the cell was empty, or pushed in string mode
+	Depth       int           // The length of the current stack after the tick
+	Top         []Value       // Up to TraceTop values from the top of the current stack, top last
+	P           int           // The index of the current stack
+	StringMode  string        `json:",omitempty"` // The quote that opened string mode, if it was on when the cell was read
+	DeepSea     bool          `json:",omitempty"`
+	Output      string        `json:",omitempty"` // What "o" and "n" wrote during the tick
+	Write       *SnapshotCell `json:",omitempty"` // The cell "p" wrote, and the value it wrote
+	End         bool          `json:",omitempty"` // Set if the tick executed ";"
+	Error       string        `json:",omitempty"` // Set if the tick failed
+}
+
+// WithTrace makes the CodeBox call f after every tick Swim executes, including the last one, whether the ><> ends
+// or fails. Ticks cut short by a done context aren't traced, as they didn't happen. Clones share f.
+func WithTrace(f func(TraceRecord)) Option {
+	return func(cB *CodeBox) {
+		cB.trace = f
+	}
+}
+
+// traceByte records b as output of the current tick, if the CodeBox is being traced.
+func (cB *CodeBox) traceByte(b byte) {
+	if cB.trace != nil {
+		cB.traced = append(cB.traced, b)
+	}
+}
+
+// traceString records s as output of the current tick, if the CodeBox is being traced.
+func (cB *CodeBox) traceString(s string) {
+	if cB.trace != nil {
+		cB.traced = append(cB.traced, s...)
+	}
+}
+
+// traceRune records r as output of the current tick, if the CodeBox is being traced.
+func (cB *CodeBox) traceRune(r rune) {
+	if cB.trace != nil {
+		var b [utf8.UTFMax]byte
+		cB.traced = append(cB.traced, b[:utf8.EncodeRune(b[:], r)]...)
+	}
+}
+
+// traceTick passes the record of the tick that just executed the cell holding v at x, y to the trace function.
+// mode is the string mode the tick started in.
+func (cB *CodeBox) traceTick(x, y int, dir Direction, mode byte, v Value, op byte, isOp, end bool, err error) {
+	rec := TraceRecord{
+		Tick:    cB.tick,
+		X:       x,
+		Y:       y,
+		Dir:     dir,
+		Cell:    v,
+		P:       cB.p,
+		DeepSea: cB.deepSea,
+		Output:  string(cB.traced),
+		End:     end,
+	}
+	cB.traced = cB.traced[:0]
+	if isOp {
+		rec.Instruction = string(rune(op))
+	}
+	if cB.p >= 0 && cB.p < len(cB.stacks) {
+		s := cB.stacks[cB.p]
+		rec.Depth = s.Len()
+		top := rec.Depth
+		if top > TraceTop {
+			top = TraceTop
+		}
+		rec.Top = make([]Value, top)
+		for i := range rec.Top {
+			rec.Top[i] = *s.top(top - 1 - i)
+		}
+	}
+	if mode != 0 {
+		rec.StringMode = string(rune(mode))
+	}
+	if cB.wroteCell {
+		rec.Write = &SnapshotCell{cB.wrote.x, cB.wrote.y, cB.box.get(cB.wrote.x, cB.wrote.y)}
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	}
+	cB.trace(rec)
+}
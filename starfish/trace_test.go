@@ -0,0 +1,76 @@
+package starfish
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	var recs []TraceRecord
+	cB := NewCodeBox(`"ih"oo25*00p1n;`, nil, false, WithOutput(ioutil.Discard), WithTrace(func(r TraceRecord) {
+		recs = append(recs, r)
+	}))
+	if _, err := cB.Run(context.Background(), RunOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 15 {
+		t.Fatalf("got %d records, expected 15", len(recs))
+	}
+	for i, r := range recs {
+		if r.Tick != int64(i+1) || r.X != i || r.Y != 0 || r.Dir != Right {
+			t.Errorf("record %d is tick %d at %d,%d swimming %v", i, r.Tick, r.X, r.Y, r.Dir)
+		}
+	}
+
+	if r := recs[1]; r.Instruction != "" || r.StringMode != `"` || r.Depth != 1 || r.Top[0] != Float('i') {
+		t.Errorf("string mode push: %+v", r)
+	}
+	if recs[0].StringMode != "" || recs[3].StringMode != `"` || recs[4].StringMode != "" {
+		t.Errorf("string mode of the quotes: %+v %+v", recs[0], recs[3])
+	}
+	if r := recs[4]; r.Instruction != "o" || r.Output != "h" || r.Depth != 1 {
+		t.Errorf("o: %+v", r)
+	}
+	if r := recs[11]; r.Instruction != "p" || r.Write == nil || *r.Write != (SnapshotCell{0, 0, Float(10)}) {
+		t.Errorf("p: %+v", r)
+	}
+	if r := recs[13]; r.Output != "1" || recs[12].Output != "" {
+		t.Errorf("n: %+v", r)
+	}
+	if r := recs[14]; !r.End || r.Instruction != ";" {
+		t.Errorf("end: %+v", r)
+	}
+
+	b, err := json.Marshal(recs[4])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"Output":"h"`) || strings.Contains(string(b), "Write") {
+		t.Errorf("JSON: %s", b)
+	}
+}
+
+func TestTraceTop(t *testing.T) {
+	var last TraceRecord
+	cB := NewCodeBox("0123456789a;", nil, false, WithTrace(func(r TraceRecord) {
+		last = r
+	}))
+	cB.Run(context.Background(), RunOptions{})
+	if last.Depth != 11 || len(last.Top) != TraceTop || last.Top[0] != Float(3) || last.Top[TraceTop-1] != Float(10) {
+		t.Errorf("%+v", last)
+	}
+}
+
+func TestTraceFailed(t *testing.T) {
+	var recs []TraceRecord
+	cB := NewCodeBox("1~~", nil, false, WithTrace(func(r TraceRecord) {
+		recs = append(recs, r)
+	}))
+	cB.Run(context.Background(), RunOptions{})
+	if len(recs) != 3 || recs[2].Error == "" || recs[1].Error != "" {
+		t.Errorf("%+v", recs)
+	}
+}